@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"os/exec"
+	"sync"
 
+	"github.com/elazarl/gosloppy/imports"
 	"github.com/elazarl/gosloppy/instrument"
 	"github.com/elazarl/gosloppy/patch"
 	"github.com/elazarl/gosloppy/scopes"
@@ -15,7 +21,74 @@ func usage() {
 run tests:
 gosloppy test <go test switches>
 build a binary:
-gosloppy build <go build switches>`)
+gosloppy build <go build switches>
+test, build or vet several packages, instrumented together:
+gosloppy test|build|vet <switches> ./... or packages
+run a package, or go files, with arguments:
+gosloppy run <go run switches> package|files [arguments]
+warn about every error gosloppy fixed (or fail with -warn=error):
+gosloppy build|test|run -warn[=error] <switches>
+do not rewrite must(f()) into f() with a panic on a non-nil error:
+gosloppy build|test|run -nomust <switches>
+do not rewrite orlog(f()) into f() with a log of a non-nil error:
+gosloppy build|test|run -noorlog <switches>
+find unused variables and imports with the type checker, exactly as the compiler does:
+gosloppy build|test|run -types <switches>
+instrument every package, even ones cached since they were last instrumented:
+gosloppy build|test|run -nocache <switches>
+remove the cache of instrumented packages:
+gosloppy clean -cache
+run a script, statements with no package clause, e.g. after #!/usr/bin/env -S gosloppy script
+gosloppy script file.gos [args]
+run statements as you type them, printing the values of expressions:
+gosloppy repl
+build, test or run again whenever the sources change:
+gosloppy watch build|test|run <switches>
+vet the sloppified package:
+gosloppy vet <go vet switches>
+list packages that would be sloppified:
+gosloppy list [-test] [packages]
+run go generate:
+gosloppy generate <go generate switches>
+print the sloppified sources, or a unified diff against the originals:
+gosloppy sloppify [-diff] [-test] [package|files]
+remove unused variables and imports, and add missing imports, in place:
+gosloppy fix [package|files]`)
+}
+
+// fix makes the given package conform to the spec, by removing unused variables and imports
+// and by adding missing imports. Removing a variable might make another one unused, so we
+// repeat until nothing changes.
+func fix(args ...string) error {
+	// names we could not import are reported once, after the last iteration
+	ambiguous := new(bytes.Buffer)
+	for i := 0; i < 10; i++ {
+		changed := false
+		ambiguous.Reset()
+		f := func(p *patch.PatchableFile) patch.Patches {
+			fixunused := visitors.NewFixUnused(p)
+			autoimport := visitors.NewLocalAutoImporter(p)
+			autoimport.InPlace = true
+			scopes.WalkFile(visitors.NewMultiVisitor(visitors.NewUnused(fixunused), autoimport), p.File)
+			autoimport.Ambiguous.FprintErrors(ambiguous, p.Fset)
+			// Adding imports might rewrite the import declaration an unused import is removed
+			// from, so we add them only once nothing is removed.
+			patches := fixunused.Patches()
+			if len(patches) == 0 {
+				patches = autoimport.Patches
+			}
+			changed = changed || len(patches) > 0
+			return patches
+		}
+		if err := instrument.InstrumentCmd(f, append([]string{"go", "inline"}, args...)...); err != nil {
+			return err
+		}
+		if !changed {
+			break
+		}
+	}
+	os.Stderr.Write(ambiguous.Bytes())
+	return nil
 }
 
 func main() {
@@ -23,14 +96,93 @@ func main() {
 		usage()
 		return
 	}
+	// if we cannot list the installed standard library, we'll use the snapshot we have
+	imports.LoadStdlib()
+	if os.Args[1] == "fix" {
+		if err := fix(os.Args[2:]...); err != nil {
+			fmt.Println(err)
+			os.Exit(-1)
+		}
+		return
+	}
+	// our patches depend on our flags alone, which are part of the cache's identity
+	instrument.UseCache = true
+	// must and orlog are rewritten by the types of their arguments, which may use packages
+	// that are auto imported
+	patch.MissingImports = visitors.MissingImports
+	fl := flag.NewFlagSet("", flag.ContinueOnError)
+	warn := warnFlag("false")
+	fl.Var(&warn, "warn", "print a warning for every error gosloppy patched, -warn=error fails if there are any")
+	nomust := fl.Bool("nomust", false, "do not rewrite "+visitors.MustKeyword+"(f()) into a panic on error")
+	noorlog := fl.Bool("noorlog", false, "do not rewrite "+visitors.OrLogKeyword+"(f()) into a log of an error")
+	typecheck := fl.Bool("types", false, "find unused variables and imports with the type checker")
+	// packages are patched concurrently
+	var mu sync.Mutex
+	nwarnings := 0
 	f := func(p *patch.PatchableFile) patch.Patches {
-		patches := &visitors.PatchUnused{patch.Patches{}}
-		autoimport := visitors.NewAutoImporter(p.File)
-		scopes.WalkFile(visitors.NewMultiVisitor(visitors.NewUnused(patches), autoimport), p.File)
-		return append(patches.Patches, autoimport.Patches...)
+		patches := &visitors.PatchUnused{Patches: patch.Patches{}}
+		autoimport := visitors.NewLocalAutoImporter(p)
+		shorterror := visitors.NewShortError(p)
+		orlog := visitors.NewOrLog(p)
+		var unused scopes.Visitor = visitors.NewUnused(patches)
+		if *typecheck {
+			unused = visitors.NewTypesUnused(p, patches)
+		}
+		vs := []scopes.Visitor{unused, autoimport}
+		if !*nomust {
+			vs = append(vs, shorterror)
+		}
+		if !*noorlog {
+			vs = append(vs, orlog)
+		}
+		scopes.WalkFile(visitors.NewMultiVisitor(vs...), p.File)
+		// the compiler will only say the name is undefined
+		autoimport.Ambiguous.FprintErrors(instrument.Output(p), p.Fset)
+		shorterror.Errors().FprintErrors(instrument.Output(p), p.Fset)
+		orlog.Errors().FprintErrors(instrument.Output(p), p.Fset)
+		if warn != "false" {
+			warnings := append(patches.Warnings, autoimport.Warnings...)
+			warnings.Fprint(instrument.Output(p), p.Fset)
+			mu.Lock()
+			nwarnings += len(warnings)
+			mu.Unlock()
+		}
+		return append(append(patches.Patches, autoimport.Patches...), append(shorterror.Patches(), orlog.Patches()...)...)
 	}
-	if err := instrument.InstrumentCmd(f, os.Args...); err != nil {
+	if err := instrument.InstrumentCmdWithFlags(fl, f, os.Args...); err != nil {
+		// a script exits with its own status, its errors are already printed
+		if exit, ok := err.(*exec.ExitError); ok && os.Args[1] == "script" {
+			os.Exit(exit.ExitCode())
+		}
+		// as go run does, run reports the program's status and exits with it
+		if exit, ok := err.(*exec.ExitError); ok && os.Args[1] == "run" {
+			fmt.Fprintln(os.Stderr, exit)
+			os.Exit(exit.ExitCode())
+		}
 		fmt.Println(err)
 		os.Exit(-1)
 	}
+	if warn == "error" && nwarnings > 0 {
+		fmt.Fprintln(os.Stderr, nwarnings, "errors sloppified")
+		os.Exit(1)
+	}
 }
+
+// warnFlag is the value of the -warn flag, either "true", "false" or "error"
+type warnFlag string
+
+func (w *warnFlag) String() string { return string(*w) }
+
+func (w *warnFlag) Set(v string) error {
+	if v != "true" && v != "false" && v != "error" {
+		return errors.New("-warn must be true, false or error")
+	}
+	*w = warnFlag(v)
+	// warnings are printed while patching, and cached packages are not patched again
+	if v != "false" {
+		instrument.UseCache = false
+	}
+	return nil
+}
+
+func (w *warnFlag) IsBoolFlag() bool { return true }
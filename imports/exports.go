@@ -0,0 +1,75 @@
+package imports
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// StdlibPackage returns the package of the standard library with the given import path, which
+// may be quoted, as in the Stdlib table.
+func StdlibPackage(importpath string) Package {
+	if unquoted, err := strconv.Unquote(importpath); err == nil {
+		importpath = unquoted
+	}
+	return Package{Stdlib[strconv.Quote(importpath)], importpath, filepath.Join(build.Default.GOROOT, "src", filepath.FromSlash(importpath))}
+}
+
+var (
+	exportsMu sync.Mutex
+	exports   = make(map[string]map[string]bool)
+)
+
+// Exports returns the exported top level names pkg declares, in the files that would be built
+// for the current platform. The result is cached.
+func (pkg Package) Exports() (map[string]bool, error) {
+	exportsMu.Lock()
+	names, ok := exports[pkg.Dir]
+	exportsMu.Unlock()
+	if ok {
+		return names, nil
+	}
+	buildpkg, err := build.ImportDir(pkg.Dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	names = make(map[string]bool)
+	fset := token.NewFileSet()
+	for _, name := range append(buildpkg.GoFiles, buildpkg.CgoFiles...) {
+		file, err := parser.ParseFile(fset, filepath.Join(pkg.Dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil && decl.Name.IsExported() {
+					names[decl.Name.Name] = true
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						if spec.Name.IsExported() {
+							names[spec.Name.Name] = true
+						}
+					case *ast.ValueSpec:
+						for _, id := range spec.Names {
+							if id.IsExported() {
+								names[id.Name] = true
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	exportsMu.Lock()
+	exports[pkg.Dir] = names
+	exportsMu.Unlock()
+	return names, nil
+}
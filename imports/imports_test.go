@@ -1,7 +1,12 @@
 package imports
 
 import (
+	"fmt"
 	"go/ast"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 )
 
@@ -14,3 +19,176 @@ func TestGetPackageName(t *testing.T) {
 		}
 	}
 }
+
+func TestModulePackageName(t *testing.T) {
+	root, err := ioutil.TempDir("", "gosloppy.imports.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer os.Setenv("GO111MODULE", os.Getenv("GO111MODULE"))
+	os.Setenv("GO111MODULE", "on")
+	if err := os.MkdirAll(filepath.Join(root, "go-lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/s1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "go-lib", "lib.go"), []byte("package lib\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	// the package name is not the last element of its import path
+	if name := getNameOrGuess(&ast.ImportSpec{Path: &ast.BasicLit{Value: `"example.com/s1/go-lib"`}}); name != "lib" {
+		t.Error("Expected package lib, got", name)
+	}
+}
+
+func TestLoadStdlib(t *testing.T) {
+	stdlib, revstdlib := Stdlib, RevStdlib
+	Stdlib, RevStdlib = make(map[string]string), make(map[string][]string)
+	defer func() { Stdlib, RevStdlib = stdlib, revstdlib }()
+	cachedir, err := ioutil.TempDir("", "gosloppy.imports.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cachedir)
+	defer os.Setenv("XDG_CACHE_HOME", os.Getenv("XDG_CACHE_HOME"))
+	os.Setenv("XDG_CACHE_HOME", cachedir)
+	if err := LoadStdlib(); err != nil {
+		t.Fatal("Cannot load stdlib", err)
+	}
+	if Stdlib[`"net/http"`] != "http" || fmt.Sprint(RevStdlib["http"]) != "[\"net/http\"]" {
+		t.Error("Expected net/http in tables, got", Stdlib[`"net/http"`], RevStdlib["http"])
+	}
+	if _, ok := Stdlib[`"internal/cpu"`]; ok {
+		t.Error("internal package internal/cpu in stdlib table")
+	}
+	// second time it should be read from the cache
+	cachefiles, err := filepath.Glob(filepath.Join(cachedir, "gosloppy", "stdlib-*.json"))
+	if err != nil || len(cachefiles) != 1 {
+		t.Fatal("Expected a single cache file, got", cachefiles, err)
+	}
+	if err := writeStdlibCache(cachefiles[0], map[string]string{"koko/moko": "moko"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadStdlib(); err != nil {
+		t.Fatal("Cannot load stdlib", err)
+	}
+	if len(Stdlib) != 1 || Stdlib[`"koko/moko"`] != "moko" || fmt.Sprint(RevStdlib) != "map[moko:[\"koko/moko\"]]" {
+		t.Error("Expected tables from cache, got", Stdlib, RevStdlib)
+	}
+}
+
+func TestFindModule(t *testing.T) {
+	root, err := ioutil.TempDir("", "gosloppy.imports.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer os.Setenv("GO111MODULE", os.Getenv("GO111MODULE"))
+	os.Setenv("GO111MODULE", "on")
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	gomod := "// module example.com/commented\nmodule \"example.com/quoted\" // the path\n"
+	if err := ioutil.WriteFile(filepath.Join(root, "go.mod"), []byte(gomod), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir, modpath, err := FindModule(filepath.Join(root, "a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != root || modpath != "example.com/quoted" {
+		t.Error("Expected module example.com/quoted in", root, "got", modpath, "in", dir)
+	}
+	os.Setenv("GO111MODULE", "off")
+	if dir, _, err := FindModule(root); dir != "" || err != nil {
+		t.Error("Expected no module with GO111MODULE=off, got", dir, err)
+	}
+}
+
+func TestLocalPackages(t *testing.T) {
+	root, err := ioutil.TempDir("", "gosloppy.imports.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer os.Setenv("GO111MODULE", os.Getenv("GO111MODULE"))
+	os.Setenv("GO111MODULE", "on")
+	files := map[string]string{
+		"go.mod":                "module example.com/m\n",
+		"main.go":               "package main\n",
+		"lib/lib.go":            "package lib\n",
+		"lib/internal/in/in.go": "package in\n",
+		"other/x.go":            "package lib\n",
+		"testdata/t/t.go":       "package t\n",
+		"nested/go.mod":         "module example.com/nested\n",
+		"nested/n/n.go":         "package n\n",
+		"onlytest/a_test.go":    "package onlytest\n",
+		"lib/sub/sub.go":        "package sub\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkgs, err := LocalPackages(filepath.Join(root, "lib", "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := func(name string) (s []string) {
+		for _, pkg := range pkgs[name] {
+			s = append(s, pkg.ImportPath)
+		}
+		sort.Strings(s)
+		return s
+	}
+	if fmt.Sprint(paths("lib")) != "[example.com/m/lib example.com/m/other]" {
+		t.Error("Expected two lib packages, got", paths("lib"))
+	}
+	for _, name := range []string{"main", "t", "n", "onlytest"} {
+		if len(pkgs[name]) != 0 {
+			t.Error("Unexpected package", name, pkgs[name])
+		}
+	}
+	if len(pkgs["in"]) != 1 {
+		t.Fatal("Expected the internal package, got", pkgs["in"])
+	}
+	in := pkgs["in"][0]
+	if !in.CanImport(filepath.Join(root, "lib", "sub")) || in.CanImport(filepath.Join(root, "other")) {
+		t.Error("internal package", in.Dir, "should be importable only below", filepath.Join(root, "lib"))
+	}
+	if in.CanImport(in.Dir) {
+		t.Error("package should not import itself")
+	}
+}
+
+func TestExports(t *testing.T) {
+	exports, err := StdlibPackage(`"text/template"`).Exports()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Template", "New", "HTMLEscape", "FuncMap"} {
+		if !exports[name] {
+			t.Error("text/template should export", name)
+		}
+	}
+	// unexported names, methods and test files do not count
+	for _, name := range []string{"state", "Execute", "HTML", "Test"} {
+		if exports[name] {
+			t.Error("text/template should not export", name)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package imports
+
+import (
+	"bufio"
+	"errors"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Package is an importable package found by LocalPackages
+type Package struct {
+	Name       string
+	ImportPath string
+	Dir        string
+}
+
+// CanImport returns whether a package in dir is allowed to import pkg, which is not the case
+// for pkg itself, or if pkg is internal to a tree dir is not in.
+func (pkg Package) CanImport(dir string) bool {
+	if filepath.Clean(dir) == filepath.Clean(pkg.Dir) {
+		return false
+	}
+	elts := strings.Split(filepath.ToSlash(pkg.Dir), "/")
+	for i := len(elts) - 1; i > 0; i-- {
+		if elts[i] == "internal" {
+			parent := filepath.FromSlash(strings.Join(elts[:i], "/"))
+			return strings.HasPrefix(filepath.Clean(dir)+string(filepath.Separator), parent+string(filepath.Separator))
+		}
+	}
+	return true
+}
+
+var (
+	localPackagesMu sync.Mutex
+	localPackages   = make(map[string]map[string][]Package)
+)
+
+// LocalPackages returns the importable packages, by package name, of the module containing dir.
+// If dir is not in a module, it returns the packages in $GOPATH. The result is cached, so new
+// packages will not be noticed.
+func LocalPackages(dir string) (map[string][]Package, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, modpath, err := FindModule(dir)
+	if err != nil {
+		return nil, err
+	}
+	roots := map[string]string{}
+	if root != "" {
+		roots[root] = modpath
+	} else {
+		for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+			roots[filepath.Join(gopath, "src")] = ""
+		}
+	}
+	pkgs := make(map[string][]Package)
+	localPackagesMu.Lock()
+	defer localPackagesMu.Unlock()
+	for root, prefix := range roots {
+		if _, ok := localPackages[root]; !ok {
+			localPackages[root] = walkPackages(root, prefix)
+		}
+		for name, found := range localPackages[root] {
+			pkgs[name] = append(pkgs[name], found...)
+		}
+	}
+	return pkgs, nil
+}
+
+// walkPackages returns the packages below root. Their import path is their path relative to
+// root, prefixed with prefix.
+func walkPackages(root, prefix string) map[string][]Package {
+	pkgs := make(map[string][]Package)
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if path != root {
+			// the go tool ignores those, and nested modules are not part of our module
+			if name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+		}
+		pkgname := packageName(path)
+		if pkgname == "" || pkgname == "main" {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		importpath := filepath.ToSlash(filepath.Join(prefix, rel))
+		if prefix == "" && rel == "." {
+			return nil
+		}
+		pkgs[pkgname] = append(pkgs[pkgname], Package{pkgname, importpath, path})
+		return nil
+	})
+	return pkgs
+}
+
+// packageName returns the package name declared in the non test go files of dir, or "" if it
+// has none
+func packageName(dir string) string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return ""
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.PackageClauseOnly)
+		if err != nil || f.Name.Name == "documentation" {
+			continue
+		}
+		return f.Name.Name
+	}
+	return ""
+}
+
+// FindModule returns the directory containing the go.mod of the module dir is in, and the
+// module path it declares. It returns "" if dir is not in a module, or if modules are turned off
+// with GO111MODULE=off.
+func FindModule(dir string) (root, modpath string, err error) {
+	if os.Getenv("GO111MODULE") == "off" {
+		return "", "", nil
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return "", "", err
+	}
+	for {
+		gomod := filepath.Join(dir, "go.mod")
+		if info, err := os.Stat(gomod); err == nil && !info.IsDir() {
+			modpath, err := readModulePath(gomod)
+			if err != nil {
+				return "", "", err
+			}
+			return dir, modpath, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", nil
+		}
+		dir = parent
+	}
+}
+
+// readModulePath returns the module path declared in the go.mod file gomod
+func readModulePath(gomod string) (string, error) {
+	f, err := os.Open(gomod)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		modpath := fields[1]
+		if strings.HasPrefix(modpath, `"`) || strings.HasPrefix(modpath, "`") {
+			if modpath, err = strconv.Unquote(modpath); err != nil {
+				return "", errors.New(gomod + ": malformed module path " + fields[1])
+			}
+		}
+		return modpath, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New(gomod + ": no module directive")
+}
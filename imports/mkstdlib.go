@@ -0,0 +1,80 @@
+//go:build ignore
+
+// mkstdlib regenerates stdlib.go, the static snapshot of the standard library packages, from the
+// installed go toolchain. Run it with
+//     go generate github.com/elazarl/gosloppy/imports
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+func main() {
+	out, err := exec.Command("go", "list", "-f", "{{.ImportPath}} {{.Name}}", "std").Output()
+	if err != nil {
+		log.Fatal("go list std: ", err)
+	}
+	version, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		log.Fatal("go env GOVERSION: ", err)
+	}
+	names := make(map[string]string)
+	rev := make(map[string][]string)
+	paths := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || isInternal(fields[0]) {
+			continue
+		}
+		names[fields[0]] = fields[1]
+		rev[fields[1]] = append(rev[fields[1]], fields[0])
+		paths = append(paths, fields[0])
+	}
+	sort.Strings(paths)
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "// Code generated by mkstdlib.go from %s; DO NOT EDIT.\n\n", strings.TrimSpace(string(version)))
+	fmt.Fprintln(buf, "package imports\n")
+	fmt.Fprintln(buf, "var Stdlib = map[string]string{")
+	for _, path := range paths {
+		fmt.Fprintf(buf, "\t`%q`: %q,\n", path, names[path])
+	}
+	fmt.Fprintln(buf, "}\n")
+	fmt.Fprintln(buf, "var RevStdlib = map[string][]string{")
+	revnames := []string{}
+	for name := range rev {
+		revnames = append(revnames, name)
+	}
+	sort.Strings(revnames)
+	for _, name := range revnames {
+		quoted := []string{}
+		for _, path := range rev[name] {
+			quoted = append(quoted, fmt.Sprintf("`%q`", path))
+		}
+		fmt.Fprintf(buf, "\t%q: []string{%s},\n", name, strings.Join(quoted, ", "))
+	}
+	fmt.Fprintln(buf, "}")
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("stdlib.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// isInternal returns whether path cannot be imported by user code
+func isInternal(path string) bool {
+	for _, elt := range strings.Split(path, "/") {
+		if elt == "internal" || elt == "vendor" {
+			return true
+		}
+	}
+	return false
+}
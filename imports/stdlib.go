@@ -0,0 +1,363 @@
+// Code generated by mkstdlib.go from go1.27.1; DO NOT EDIT.
+
+package imports
+
+var Stdlib = map[string]string{
+	`"archive/tar"`:            "tar",
+	`"archive/zip"`:            "zip",
+	`"bufio"`:                  "bufio",
+	`"bytes"`:                  "bytes",
+	`"cmp"`:                    "cmp",
+	`"compress/bzip2"`:         "bzip2",
+	`"compress/flate"`:         "flate",
+	`"compress/gzip"`:          "gzip",
+	`"compress/lzw"`:           "lzw",
+	`"compress/zlib"`:          "zlib",
+	`"container/heap"`:         "heap",
+	`"container/list"`:         "list",
+	`"container/ring"`:         "ring",
+	`"context"`:                "context",
+	`"crypto"`:                 "crypto",
+	`"crypto/aes"`:             "aes",
+	`"crypto/cipher"`:          "cipher",
+	`"crypto/des"`:             "des",
+	`"crypto/dsa"`:             "dsa",
+	`"crypto/ecdh"`:            "ecdh",
+	`"crypto/ecdsa"`:           "ecdsa",
+	`"crypto/ed25519"`:         "ed25519",
+	`"crypto/elliptic"`:        "elliptic",
+	`"crypto/fips140"`:         "fips140",
+	`"crypto/hkdf"`:            "hkdf",
+	`"crypto/hmac"`:            "hmac",
+	`"crypto/hpke"`:            "hpke",
+	`"crypto/md5"`:             "md5",
+	`"crypto/mldsa"`:           "mldsa",
+	`"crypto/mlkem"`:           "mlkem",
+	`"crypto/mlkem/mlkemtest"`: "mlkemtest",
+	`"crypto/pbkdf2"`:          "pbkdf2",
+	`"crypto/rand"`:            "rand",
+	`"crypto/rc4"`:             "rc4",
+	`"crypto/rsa"`:             "rsa",
+	`"crypto/sha1"`:            "sha1",
+	`"crypto/sha256"`:          "sha256",
+	`"crypto/sha3"`:            "sha3",
+	`"crypto/sha512"`:          "sha512",
+	`"crypto/subtle"`:          "subtle",
+	`"crypto/tls"`:             "tls",
+	`"crypto/x509"`:            "x509",
+	`"crypto/x509/pkix"`:       "pkix",
+	`"database/sql"`:           "sql",
+	`"database/sql/driver"`:    "driver",
+	`"debug/buildinfo"`:        "buildinfo",
+	`"debug/dwarf"`:            "dwarf",
+	`"debug/elf"`:              "elf",
+	`"debug/gosym"`:            "gosym",
+	`"debug/macho"`:            "macho",
+	`"debug/pe"`:               "pe",
+	`"debug/plan9obj"`:         "plan9obj",
+	`"embed"`:                  "embed",
+	`"encoding"`:               "encoding",
+	`"encoding/ascii85"`:       "ascii85",
+	`"encoding/asn1"`:          "asn1",
+	`"encoding/base32"`:        "base32",
+	`"encoding/base64"`:        "base64",
+	`"encoding/binary"`:        "binary",
+	`"encoding/csv"`:           "csv",
+	`"encoding/gob"`:           "gob",
+	`"encoding/hex"`:           "hex",
+	`"encoding/json"`:          "json",
+	`"encoding/json/jsontext"`: "jsontext",
+	`"encoding/json/v2"`:       "json",
+	`"encoding/pem"`:           "pem",
+	`"encoding/xml"`:           "xml",
+	`"errors"`:                 "errors",
+	`"expvar"`:                 "expvar",
+	`"flag"`:                   "flag",
+	`"fmt"`:                    "fmt",
+	`"go/ast"`:                 "ast",
+	`"go/build"`:               "build",
+	`"go/build/constraint"`:    "constraint",
+	`"go/constant"`:            "constant",
+	`"go/doc"`:                 "doc",
+	`"go/doc/comment"`:         "comment",
+	`"go/format"`:              "format",
+	`"go/importer"`:            "importer",
+	`"go/parser"`:              "parser",
+	`"go/printer"`:             "printer",
+	`"go/scanner"`:             "scanner",
+	`"go/token"`:               "token",
+	`"go/types"`:               "types",
+	`"go/version"`:             "version",
+	`"hash"`:                   "hash",
+	`"hash/adler32"`:           "adler32",
+	`"hash/crc32"`:             "crc32",
+	`"hash/crc64"`:             "crc64",
+	`"hash/fnv"`:               "fnv",
+	`"hash/maphash"`:           "maphash",
+	`"html"`:                   "html",
+	`"html/template"`:          "template",
+	`"image"`:                  "image",
+	`"image/color"`:            "color",
+	`"image/color/palette"`:    "palette",
+	`"image/draw"`:             "draw",
+	`"image/gif"`:              "gif",
+	`"image/jpeg"`:             "jpeg",
+	`"image/png"`:              "png",
+	`"index/suffixarray"`:      "suffixarray",
+	`"io"`:                     "io",
+	`"io/fs"`:                  "fs",
+	`"io/ioutil"`:              "ioutil",
+	`"iter"`:                   "iter",
+	`"log"`:                    "log",
+	`"log/slog"`:               "slog",
+	`"log/syslog"`:             "syslog",
+	`"maps"`:                   "maps",
+	`"math"`:                   "math",
+	`"math/big"`:               "big",
+	`"math/bits"`:              "bits",
+	`"math/cmplx"`:             "cmplx",
+	`"math/rand"`:              "rand",
+	`"math/rand/v2"`:           "rand",
+	`"mime"`:                   "mime",
+	`"mime/multipart"`:         "multipart",
+	`"mime/quotedprintable"`:   "quotedprintable",
+	`"net"`:                    "net",
+	`"net/http"`:               "http",
+	`"net/http/cgi"`:           "cgi",
+	`"net/http/cookiejar"`:     "cookiejar",
+	`"net/http/fcgi"`:          "fcgi",
+	`"net/http/httptest"`:      "httptest",
+	`"net/http/httptrace"`:     "httptrace",
+	`"net/http/httputil"`:      "httputil",
+	`"net/http/pprof"`:         "pprof",
+	`"net/mail"`:               "mail",
+	`"net/netip"`:              "netip",
+	`"net/rpc"`:                "rpc",
+	`"net/rpc/jsonrpc"`:        "jsonrpc",
+	`"net/smtp"`:               "smtp",
+	`"net/textproto"`:          "textproto",
+	`"net/url"`:                "url",
+	`"os"`:                     "os",
+	`"os/exec"`:                "exec",
+	`"os/signal"`:              "signal",
+	`"os/user"`:                "user",
+	`"path"`:                   "path",
+	`"path/filepath"`:          "filepath",
+	`"plugin"`:                 "plugin",
+	`"reflect"`:                "reflect",
+	`"regexp"`:                 "regexp",
+	`"regexp/syntax"`:          "syntax",
+	`"runtime"`:                "runtime",
+	`"runtime/cgo"`:            "cgo",
+	`"runtime/coverage"`:       "coverage",
+	`"runtime/debug"`:          "debug",
+	`"runtime/metrics"`:        "metrics",
+	`"runtime/pprof"`:          "pprof",
+	`"runtime/race"`:           "race",
+	`"runtime/trace"`:          "trace",
+	`"slices"`:                 "slices",
+	`"sort"`:                   "sort",
+	`"strconv"`:                "strconv",
+	`"strings"`:                "strings",
+	`"structs"`:                "structs",
+	`"sync"`:                   "sync",
+	`"sync/atomic"`:            "atomic",
+	`"syscall"`:                "syscall",
+	`"testing"`:                "testing",
+	`"testing/cryptotest"`:     "cryptotest",
+	`"testing/fstest"`:         "fstest",
+	`"testing/iotest"`:         "iotest",
+	`"testing/quick"`:          "quick",
+	`"testing/slogtest"`:       "slogtest",
+	`"testing/synctest"`:       "synctest",
+	`"text/scanner"`:           "scanner",
+	`"text/tabwriter"`:         "tabwriter",
+	`"text/template"`:          "template",
+	`"text/template/parse"`:    "parse",
+	`"time"`:                   "time",
+	`"time/tzdata"`:            "tzdata",
+	`"unicode"`:                "unicode",
+	`"unicode/utf16"`:          "utf16",
+	`"unicode/utf8"`:           "utf8",
+	`"unique"`:                 "unique",
+	`"unsafe"`:                 "unsafe",
+	`"uuid"`:                   "uuid",
+	`"weak"`:                   "weak",
+}
+
+var RevStdlib = map[string][]string{
+	"adler32":         []string{`"hash/adler32"`},
+	"aes":             []string{`"crypto/aes"`},
+	"ascii85":         []string{`"encoding/ascii85"`},
+	"asn1":            []string{`"encoding/asn1"`},
+	"ast":             []string{`"go/ast"`},
+	"atomic":          []string{`"sync/atomic"`},
+	"base32":          []string{`"encoding/base32"`},
+	"base64":          []string{`"encoding/base64"`},
+	"big":             []string{`"math/big"`},
+	"binary":          []string{`"encoding/binary"`},
+	"bits":            []string{`"math/bits"`},
+	"bufio":           []string{`"bufio"`},
+	"build":           []string{`"go/build"`},
+	"buildinfo":       []string{`"debug/buildinfo"`},
+	"bytes":           []string{`"bytes"`},
+	"bzip2":           []string{`"compress/bzip2"`},
+	"cgi":             []string{`"net/http/cgi"`},
+	"cgo":             []string{`"runtime/cgo"`},
+	"cipher":          []string{`"crypto/cipher"`},
+	"cmp":             []string{`"cmp"`},
+	"cmplx":           []string{`"math/cmplx"`},
+	"color":           []string{`"image/color"`},
+	"comment":         []string{`"go/doc/comment"`},
+	"constant":        []string{`"go/constant"`},
+	"constraint":      []string{`"go/build/constraint"`},
+	"context":         []string{`"context"`},
+	"cookiejar":       []string{`"net/http/cookiejar"`},
+	"coverage":        []string{`"runtime/coverage"`},
+	"crc32":           []string{`"hash/crc32"`},
+	"crc64":           []string{`"hash/crc64"`},
+	"crypto":          []string{`"crypto"`},
+	"cryptotest":      []string{`"testing/cryptotest"`},
+	"csv":             []string{`"encoding/csv"`},
+	"debug":           []string{`"runtime/debug"`},
+	"des":             []string{`"crypto/des"`},
+	"doc":             []string{`"go/doc"`},
+	"draw":            []string{`"image/draw"`},
+	"driver":          []string{`"database/sql/driver"`},
+	"dsa":             []string{`"crypto/dsa"`},
+	"dwarf":           []string{`"debug/dwarf"`},
+	"ecdh":            []string{`"crypto/ecdh"`},
+	"ecdsa":           []string{`"crypto/ecdsa"`},
+	"ed25519":         []string{`"crypto/ed25519"`},
+	"elf":             []string{`"debug/elf"`},
+	"elliptic":        []string{`"crypto/elliptic"`},
+	"embed":           []string{`"embed"`},
+	"encoding":        []string{`"encoding"`},
+	"errors":          []string{`"errors"`},
+	"exec":            []string{`"os/exec"`},
+	"expvar":          []string{`"expvar"`},
+	"fcgi":            []string{`"net/http/fcgi"`},
+	"filepath":        []string{`"path/filepath"`},
+	"fips140":         []string{`"crypto/fips140"`},
+	"flag":            []string{`"flag"`},
+	"flate":           []string{`"compress/flate"`},
+	"fmt":             []string{`"fmt"`},
+	"fnv":             []string{`"hash/fnv"`},
+	"format":          []string{`"go/format"`},
+	"fs":              []string{`"io/fs"`},
+	"fstest":          []string{`"testing/fstest"`},
+	"gif":             []string{`"image/gif"`},
+	"gob":             []string{`"encoding/gob"`},
+	"gosym":           []string{`"debug/gosym"`},
+	"gzip":            []string{`"compress/gzip"`},
+	"hash":            []string{`"hash"`},
+	"heap":            []string{`"container/heap"`},
+	"hex":             []string{`"encoding/hex"`},
+	"hkdf":            []string{`"crypto/hkdf"`},
+	"hmac":            []string{`"crypto/hmac"`},
+	"hpke":            []string{`"crypto/hpke"`},
+	"html":            []string{`"html"`},
+	"http":            []string{`"net/http"`},
+	"httptest":        []string{`"net/http/httptest"`},
+	"httptrace":       []string{`"net/http/httptrace"`},
+	"httputil":        []string{`"net/http/httputil"`},
+	"image":           []string{`"image"`},
+	"importer":        []string{`"go/importer"`},
+	"io":              []string{`"io"`},
+	"iotest":          []string{`"testing/iotest"`},
+	"ioutil":          []string{`"io/ioutil"`},
+	"iter":            []string{`"iter"`},
+	"jpeg":            []string{`"image/jpeg"`},
+	"json":            []string{`"encoding/json"`, `"encoding/json/v2"`},
+	"jsonrpc":         []string{`"net/rpc/jsonrpc"`},
+	"jsontext":        []string{`"encoding/json/jsontext"`},
+	"list":            []string{`"container/list"`},
+	"log":             []string{`"log"`},
+	"lzw":             []string{`"compress/lzw"`},
+	"macho":           []string{`"debug/macho"`},
+	"mail":            []string{`"net/mail"`},
+	"maphash":         []string{`"hash/maphash"`},
+	"maps":            []string{`"maps"`},
+	"math":            []string{`"math"`},
+	"md5":             []string{`"crypto/md5"`},
+	"metrics":         []string{`"runtime/metrics"`},
+	"mime":            []string{`"mime"`},
+	"mldsa":           []string{`"crypto/mldsa"`},
+	"mlkem":           []string{`"crypto/mlkem"`},
+	"mlkemtest":       []string{`"crypto/mlkem/mlkemtest"`},
+	"multipart":       []string{`"mime/multipart"`},
+	"net":             []string{`"net"`},
+	"netip":           []string{`"net/netip"`},
+	"os":              []string{`"os"`},
+	"palette":         []string{`"image/color/palette"`},
+	"parse":           []string{`"text/template/parse"`},
+	"parser":          []string{`"go/parser"`},
+	"path":            []string{`"path"`},
+	"pbkdf2":          []string{`"crypto/pbkdf2"`},
+	"pe":              []string{`"debug/pe"`},
+	"pem":             []string{`"encoding/pem"`},
+	"pkix":            []string{`"crypto/x509/pkix"`},
+	"plan9obj":        []string{`"debug/plan9obj"`},
+	"plugin":          []string{`"plugin"`},
+	"png":             []string{`"image/png"`},
+	"pprof":           []string{`"net/http/pprof"`, `"runtime/pprof"`},
+	"printer":         []string{`"go/printer"`},
+	"quick":           []string{`"testing/quick"`},
+	"quotedprintable": []string{`"mime/quotedprintable"`},
+	"race":            []string{`"runtime/race"`},
+	"rand":            []string{`"crypto/rand"`, `"math/rand"`, `"math/rand/v2"`},
+	"rc4":             []string{`"crypto/rc4"`},
+	"reflect":         []string{`"reflect"`},
+	"regexp":          []string{`"regexp"`},
+	"ring":            []string{`"container/ring"`},
+	"rpc":             []string{`"net/rpc"`},
+	"rsa":             []string{`"crypto/rsa"`},
+	"runtime":         []string{`"runtime"`},
+	"scanner":         []string{`"go/scanner"`, `"text/scanner"`},
+	"sha1":            []string{`"crypto/sha1"`},
+	"sha256":          []string{`"crypto/sha256"`},
+	"sha3":            []string{`"crypto/sha3"`},
+	"sha512":          []string{`"crypto/sha512"`},
+	"signal":          []string{`"os/signal"`},
+	"slices":          []string{`"slices"`},
+	"slog":            []string{`"log/slog"`},
+	"slogtest":        []string{`"testing/slogtest"`},
+	"smtp":            []string{`"net/smtp"`},
+	"sort":            []string{`"sort"`},
+	"sql":             []string{`"database/sql"`},
+	"strconv":         []string{`"strconv"`},
+	"strings":         []string{`"strings"`},
+	"structs":         []string{`"structs"`},
+	"subtle":          []string{`"crypto/subtle"`},
+	"suffixarray":     []string{`"index/suffixarray"`},
+	"sync":            []string{`"sync"`},
+	"synctest":        []string{`"testing/synctest"`},
+	"syntax":          []string{`"regexp/syntax"`},
+	"syscall":         []string{`"syscall"`},
+	"syslog":          []string{`"log/syslog"`},
+	"tabwriter":       []string{`"text/tabwriter"`},
+	"tar":             []string{`"archive/tar"`},
+	"template":        []string{`"html/template"`, `"text/template"`},
+	"testing":         []string{`"testing"`},
+	"textproto":       []string{`"net/textproto"`},
+	"time":            []string{`"time"`},
+	"tls":             []string{`"crypto/tls"`},
+	"token":           []string{`"go/token"`},
+	"trace":           []string{`"runtime/trace"`},
+	"types":           []string{`"go/types"`},
+	"tzdata":          []string{`"time/tzdata"`},
+	"unicode":         []string{`"unicode"`},
+	"unique":          []string{`"unique"`},
+	"unsafe":          []string{`"unsafe"`},
+	"url":             []string{`"net/url"`},
+	"user":            []string{`"os/user"`},
+	"utf16":           []string{`"unicode/utf16"`},
+	"utf8":            []string{`"unicode/utf8"`},
+	"uuid":            []string{`"uuid"`},
+	"version":         []string{`"go/version"`},
+	"weak":            []string{`"weak"`},
+	"x509":            []string{`"crypto/x509"`},
+	"xml":             []string{`"encoding/xml"`},
+	"zip":             []string{`"archive/zip"`},
+	"zlib":            []string{`"compress/zlib"`},
+}
@@ -0,0 +1,125 @@
+package imports
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LoadStdlib replaces the Stdlib and RevStdlib tables, a snapshot of the standard library taken
+// by go generate, with the packages of the installed go toolchain. Listing the standard library
+// takes a while, so the list is cached on disk per Go version. On error the tables are left
+// untouched.
+func LoadStdlib() error {
+	version, err := goEnv("GOVERSION")
+	if err != nil {
+		return err
+	}
+	cachefile, err := stdlibCacheFile(version)
+	if err != nil {
+		return err
+	}
+	pkgs, err := readStdlibCache(cachefile)
+	if err != nil {
+		if pkgs, err = listStdlib(); err != nil {
+			return err
+		}
+		// we can do without a cache, it'll only take longer next time
+		writeStdlibCache(cachefile, pkgs)
+	}
+	setStdlib(pkgs)
+	return nil
+}
+
+func goEnv(name string) (string, error) {
+	out, err := exec.Command("go", "env", name).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// stdlibCacheFile returns the file caching the standard library packages of the given go version
+func stdlibCacheFile(version string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	// development versions look like "devel go1.22-6f8c2b2 Fri Jan 5 12:00:00 2024 +0000"
+	version = strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, version)
+	return filepath.Join(dir, "gosloppy", "stdlib-"+version+".json"), nil
+}
+
+func readStdlibCache(cachefile string) (pkgs map[string]string, err error) {
+	buf, err := ioutil.ReadFile(cachefile)
+	if err != nil {
+		return nil, err
+	}
+	return pkgs, json.Unmarshal(buf, &pkgs)
+}
+
+func writeStdlibCache(cachefile string, pkgs map[string]string) error {
+	buf, err := json.Marshal(pkgs)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cachefile), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cachefile, buf, 0644)
+}
+
+// listStdlib returns the name of each importable package in the standard library, by import path
+func listStdlib() (map[string]string, error) {
+	out, err := exec.Command("go", "list", "-f", "{{.ImportPath}} {{.Name}}", "std").Output()
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && !isInternal(fields[0]) {
+			pkgs[fields[0]] = fields[1]
+		}
+	}
+	return pkgs, nil
+}
+
+// isInternal returns whether path cannot be imported by user code
+func isInternal(path string) bool {
+	for _, elt := range strings.Split(path, "/") {
+		if elt == "internal" || elt == "vendor" {
+			return true
+		}
+	}
+	return false
+}
+
+// setStdlib replaces the content of Stdlib and RevStdlib with pkgs. The maps are modified in
+// place, since DefaultImportCache refers to Stdlib.
+func setStdlib(pkgs map[string]string) {
+	for path := range Stdlib {
+		delete(Stdlib, path)
+	}
+	for name := range RevStdlib {
+		delete(RevStdlib, name)
+	}
+	paths := []string{}
+	for path := range pkgs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		quoted := `"` + path + `"`
+		Stdlib[quoted] = pkgs[path]
+		RevStdlib[pkgs[path]] = append(RevStdlib[pkgs[path]], quoted)
+	}
+}
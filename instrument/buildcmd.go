@@ -74,29 +74,104 @@ func NewGoCmd(workdir string, args ...string) (*GoCmd, error) {
 	return NewGoCmdWithFlags(flag.NewFlagSet("", flag.ContinueOnError), workdir, args...)
 }
 
+// TestFlags are the flags of `go test` the test binary takes, with a value, e.g. `-run=A.*`
 var TestFlags = []string{
 	"bench",
 	"benchtime",
+	"blockprofile",
+	"blockprofilerate",
+	"count",
+	"coverprofile",
 	"cpu",
 	"cpuprofile",
+	"fuzz",
+	"fuzzminimizetime",
+	"fuzztime",
+	"list",
 	"memprofile",
 	"memprofilerate",
+	"mutexprofile",
+	"mutexprofilefraction",
+	"outputdir",
 	"parallel",
 	"run",
+	"shuffle",
+	"skip",
 	"timeout",
+	"trace",
 }
 
+// TestBoolFlags are the boolean flags of `go test`, e.g. `go test -json`
+var TestBoolFlags = []string{
+	"benchmem",
+	"c",
+	"cover",
+	"failfast",
+	"i",
+	"json",
+	"short",
+}
+
+// VetFlags are the boolean analyzer flags of `go vet`, e.g. `go vet -printf=false`
+var VetFlags = []string{
+	"all",
+	"asmdecl",
+	"assign",
+	"atomic",
+	"bools",
+	"buildtag",
+	"cgocall",
+	"composites",
+	"copylocks",
+	"errorsas",
+	"httpresponse",
+	"json",
+	"loopclosure",
+	"lostcancel",
+	"nilfunc",
+	"printf",
+	"shift",
+	"stdmethods",
+	"structtag",
+	"tests",
+	"unmarshal",
+	"unreachable",
+	"unsafeptr",
+	"unusedresult",
+}
+
+// ListFlags are the boolean flags of `go list`
+var ListFlags = []string{
+	"compiled",
+	"deps",
+	"e",
+	"export",
+	"find",
+	"json",
+	"m",
+	"retracted",
+	"test",
+	"u",
+	"versions",
+}
+
+// GoCommands are the go tool commands supported by GoCmd
+var GoCommands = []string{"build", "generate", "list", "run", "test", "vet"}
+
 // NewGoCmdWithFlags like NewGoCmd, but wl also parse flags configured i flagset
 func NewGoCmdWithFlags(flagset *flag.FlagSet, workdir string, args ...string) (*GoCmd, error) {
 	if len(args) < 2 {
 		return nil, errors.New("GoCmd must have at least two arguments (e.g. go build)")
 	}
-	if sort.SearchStrings([]string{"build", "run", "test"}, args[1]) > -1 {
+	if i := sort.SearchStrings(GoCommands, args[1]); i == len(GoCommands) || GoCommands[i] != args[1] {
+		return nil, errors.New("Currently only " + strings.Join(GoCommands, " ") + " commands supported. Sorry.")
+	}
+	if args[1] != "generate" {
 		flagset.Int("p", runtime.NumCPU(), "number or parallel builds")
-		for _, f := range []string{"x", "v", "n", "a", "work"} {
+		for _, f := range []string{"x", "v", "n", "a", "work", "race", "msan", "asan", "trimpath"} {
 			flagset.Bool(f, false, "")
 		}
-		for _, f := range []string{"compiler", "gccgoflags", "gcflags", "ldflags", "tags"} {
+		for _, f := range []string{"compiler", "gccgoflags", "gcflags", "ldflags", "tags", "mod", "modfile"} {
 			flagset.String(f, "", "")
 		}
 	}
@@ -104,28 +179,61 @@ func NewGoCmdWithFlags(flagset *flag.FlagSet, workdir string, args ...string) (*
 	case "run":
 	case "build":
 		flagset.String("o", "", "output: output file")
+	case "vet":
+		flagset.String("vettool", "", "")
+		flagset.String("printf.funcs", "", "")
+		for _, f := range VetFlags {
+			flagset.Bool(f, false, "")
+		}
+	case "list":
+		flagset.String("f", "", "")
+		for _, f := range ListFlags {
+			flagset.Bool(f, false, "")
+		}
+	case "generate":
+		for _, f := range []string{"x", "v", "n"} {
+			flagset.Bool(f, false, "")
+		}
+		for _, f := range []string{"run", "skip"} {
+			flagset.String(f, "", "")
+		}
 	case "test":
-		for _, f := range []string{"i", "c"} {
+		for _, f := range TestBoolFlags {
 			flagset.Bool(f, false, "")
 		}
+		for _, f := range []string{"o", "exec", "vet", "covermode", "coverpkg"} {
+			flagset.String(f, "", "")
+		}
 		for _, testflag := range TestFlags {
 			flagset.String(testflag, "", "")
 			flagset.String("test."+testflag, "", "")
 		}
-		flagset.Bool("short", false, "")
 		flagset.Bool("test.short", false, "")
 		flagset.Bool("test.v", false, "")
-	default:
-		return nil, errors.New("Currently only build run and test commands supported. Sorry.")
 	}
-	if err := flagset.Parse(args[2:]); err != nil {
+	flags, testargs := args[2:], []string(nil)
+	if args[1] == "test" {
+		// as with go test, -args and everything following it are passed to the test binary as is
+		for i, arg := range flags {
+			if arg == "-args" || arg == "--args" {
+				flags, testargs = flags[:i], flags[i:]
+				break
+			}
+		}
+	}
+	if err := flagset.Parse(flags); err != nil {
 		return nil, err
 	}
 	var params, extra []string
 	switch args[1] {
-	case "build":
+	case "build", "vet", "list", "generate":
 		params = flagset.Args()
 	case "run":
+		// as with go run, the program is either a package, or a list of go files
+		if args := flagset.Args(); len(args) > 0 && !strings.HasSuffix(args[0], ".go") {
+			params, extra = args[:1], args[1:]
+			break
+		}
 		for i, param := range flagset.Args() {
 			if !strings.HasSuffix(param, ".go") {
 				extra = flagset.Args()[i:]
@@ -141,8 +249,7 @@ func NewGoCmdWithFlags(flagset *flag.FlagSet, workdir string, args ...string) (*
 			}
 			params = append(params, param)
 		}
-	default:
-		return nil, errors.New("Currently only build run and test commands supported")
+		extra = append(extra, testargs...)
 	}
 	return &GoCmd{make(map[string]string), workdir, args[0], args[1], FromFlagSet(flagset), params, extra}, nil
 }
@@ -203,9 +310,17 @@ func (cmd *GoCmd) Retarget(newdir string) (*GoCmd, error) {
 	case "run":
 		params = []string{}
 		for _, p := range cmd.Params {
-			params = append(params, filepath.Join(newdir, filepath.Base(p)))
+			if strings.HasSuffix(p, ".go") {
+				p = filepath.Join(newdir, filepath.Base(p))
+			}
+			params = append(params, p)
 		}
 	case "test":
+		// as the test binary does not run in workdir, its name is absolute
+		if v := cmd.BuildFlags["o"]; v != "" && !filepath.IsAbs(v) {
+			buildflags["o"] = filepath.Join(workdir, v)
+		}
+	case "vet":
 	case "build":
 		v := cmd.BuildFlags["o"]
 		if v == "" {
@@ -218,9 +333,12 @@ func (cmd *GoCmd) Retarget(newdir string) (*GoCmd, error) {
 			}
 			v = name
 		}
-		buildflags["o"] = filepath.Join(workdir, v)
+		if !filepath.IsAbs(v) {
+			v = filepath.Join(workdir, v)
+		}
+		buildflags["o"] = v
 	default:
-		return nil, errors.New("No support for commands other than build test or run")
+		return nil, errors.New("No support for retargeting commands other than build test vet or run")
 	}
 	return &GoCmd{make(map[string]string), newdir, cmd.Executable, cmd.Command, buildflags, params, cmd.ExtraFlags}, nil
 }
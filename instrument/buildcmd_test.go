@@ -62,6 +62,20 @@ func TestGoCmdParsingTest(t *testing.T) {
 	expectEq("test", fmt.Sprint(cmd.Command), t)
 }
 
+func TestGoCmdParsingTestArgs(t *testing.T) {
+	cmd, err := NewGoCmd(".", "go", "test", "-v", "-args", "-foo", "bar")
+	OrFail(err, t)
+	expectEq("[]", fmt.Sprint(cmd.Params), t)
+	expectEq("[-args -foo bar]", fmt.Sprint(cmd.ExtraFlags), t)
+	expectEq("v=true", fmt.Sprint(cmd.BuildFlags), t)
+
+	cmd, err = NewGoCmd(".", "go", "test", "./p", "-args", "-v")
+	OrFail(err, t)
+	expectEq("[./p]", fmt.Sprint(cmd.Params), t)
+	expectEq("[-args -v]", fmt.Sprint(cmd.ExtraFlags), t)
+	expectEq("0", fmt.Sprint(len(cmd.BuildFlags)), t)
+}
+
 func TestGoCmdParsingTestNoPkg(t *testing.T) {
 	cmd, err := NewGoCmd(".", "go", "test", "-run", "away")
 	OrFail(err, t)
@@ -69,3 +83,59 @@ func TestGoCmdParsingTestNoPkg(t *testing.T) {
 	expectEq("run=away", fmt.Sprint(cmd.BuildFlags), t)
 	expectEq("test", fmt.Sprint(cmd.Command), t)
 }
+
+func TestGoTestCmdRetarget(t *testing.T) {
+	cmd, err := NewGoCmd(".", "go", "test", "-json", "-count", "1", "-race", "-o", "pkg.test", "./...")
+	OrFail(err, t)
+	expectEq("[./...]", fmt.Sprint(cmd.Params), t)
+	cmd, err = cmd.Retarget("temp")
+	OrFail(err, t)
+	path, err := filepath.Abs("pkg.test")
+	OrFail(err, t)
+	expected := map[string]string{"json": "true", "count": "1", "race": "true", "o": path}
+	if len(cmd.BuildFlags) != len(expected) {
+		t.Error("Expected", expected, "got", cmd.BuildFlags)
+	}
+	for name, value := range expected {
+		expectEq(value, cmd.BuildFlags[name], t)
+	}
+}
+
+func TestGoRunCmdParsing(t *testing.T) {
+	cmd, err := NewGoCmd(".", "go", "run", "-x", "a.go", "b.go", "arg", "c.go")
+	OrFail(err, t)
+	expectEq("[a.go b.go]", fmt.Sprint(cmd.Params), t)
+	expectEq("[arg c.go]", fmt.Sprint(cmd.ExtraFlags), t)
+	cmd, err = cmd.Retarget("temp")
+	OrFail(err, t)
+	expectEq(fmt.Sprint([]string{filepath.Join("temp", "a.go"), filepath.Join("temp", "b.go")}), fmt.Sprint(cmd.Params), t)
+
+	cmd, err = NewGoCmd(".", "go", "run", "./cmd/tool", "a.go", "-v")
+	OrFail(err, t)
+	expectEq("[./cmd/tool]", fmt.Sprint(cmd.Params), t)
+	expectEq("[a.go -v]", fmt.Sprint(cmd.ExtraFlags), t)
+	cmd, err = cmd.Retarget("temp")
+	OrFail(err, t)
+	expectEq("[./cmd/tool]", fmt.Sprint(cmd.Params), t)
+}
+
+func TestGoCmdParsingVetListGenerate(t *testing.T) {
+	cmd, err := NewGoCmd(".", "go", "vet", "-printf=false", "bobo")
+	OrFail(err, t)
+	expectEq("[bobo]", fmt.Sprint(cmd.Params), t)
+	expectEq("printf=false", fmt.Sprint(cmd.BuildFlags), t)
+
+	cmd, err = NewGoCmd(".", "go", "list", "-test", "bobo")
+	OrFail(err, t)
+	expectEq("[bobo]", fmt.Sprint(cmd.Params), t)
+	expectEq("test=true", fmt.Sprint(cmd.BuildFlags), t)
+
+	cmd, err = NewGoCmd(".", "go", "generate", "-run", "stringer")
+	OrFail(err, t)
+	expectEq("[]", fmt.Sprint(cmd.Params), t)
+	expectEq("run=stringer", fmt.Sprint(cmd.BuildFlags), t)
+
+	if _, err := NewGoCmd(".", "go", "install"); err == nil {
+		t.Error("Expected go install to be unsupported")
+	}
+}
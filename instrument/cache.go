@@ -0,0 +1,248 @@
+package instrument
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/elazarl/gosloppy/imports"
+	"github.com/elazarl/gosloppy/patch"
+)
+
+// UseCache makes InstrumentCmd reuse packages instrumented by earlier runs, if neither their
+// files nor the instrumentation changed, in which case the patch function is not called for
+// them. The instrumented packages are written to the same directory on every run, so the go
+// build cache can reuse their build results as well. It is off by default, as the cache tells
+// apart instrumentations by the executable and its flags alone, so a program must turn it on
+// only if its patch function depends on nothing else.
+var UseCache = false
+
+// cache is the persistent cache of instrumented packages
+type cache struct {
+	dir string
+	// identity tells apart different instrumentations of the same files
+	identity string
+	// keys maps directories of instrumented packages to their keys, so that a package's key
+	// changes with the keys of the packages it imports
+	keys map[string]string
+}
+
+// cachedFile is an instrumented go file
+type cachedFile struct {
+	Name      string
+	Content   []byte
+	SourceMap *patch.SourceMap
+	// Added are the imports patches added to the file
+	Added []string
+	// Output is what patching the file printed to Output, which is printed again whenever the
+	// file is taken from the cache
+	Output []byte
+}
+
+// CacheDir returns the directory of gosloppy's persistent cache
+func CacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gosloppy"), nil
+}
+
+// CleanCache removes gosloppy's persistent cache
+func CleanCache() error {
+	dir, err := CacheDir()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// newCache returns the cache of packages instrumented by this executable, with the given
+// parameters, e.g. the values of its flags
+func newCache(params ...string) (*cache, error) {
+	dir, err := CacheDir()
+	if err != nil {
+		return nil, err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if err := hashFile(h, exe); err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(h, runtime.Version(), build.Default.GOROOT, build.Default.GOOS, build.Default.GOARCH, build.Default.BuildTags)
+	for _, param := range params {
+		fmt.Fprintln(h, param)
+	}
+	return &cache{dir, hex.EncodeToString(h.Sum(nil)), make(map[string]string)}, nil
+}
+
+func hashFile(h io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// The directories packages are instrumented to are removed, but the maxWorkdirs most recently
+// used ones, and so are cached packages and directories that were not used for maxAge.
+const (
+	maxWorkdirs = 32
+	maxAge      = 30 * 24 * time.Hour
+)
+
+// workdir returns the directory the package with the given id is always instrumented to, when
+// instrumented from the current directory
+func (c *cache) workdir(id string) string {
+	wd, _ := os.Getwd()
+	h := sha256.Sum256([]byte(c.identity + "\n" + wd + "\n" + id))
+	return filepath.Join(c.dir, "work", hex.EncodeToString(h[:8]))
+}
+
+// use marks the directory, or entry, name as used now, see evict
+func (c *cache) use(name string) error {
+	now := time.Now()
+	return os.Chtimes(name, now, now)
+}
+
+// evict removes the directories packages were instrumented to, but the maxWorkdirs most recently
+// used ones, and the directories and cached packages that were not used for maxAge
+func (c *cache) evict() error {
+	workdirs, err := ioutil.ReadDir(filepath.Join(c.dir, "work"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	sort.Slice(workdirs, func(i, j int) bool { return workdirs[i].ModTime().After(workdirs[j].ModTime()) })
+	for i, info := range workdirs {
+		if i >= maxWorkdirs || time.Since(info.ModTime()) > maxAge {
+			if err := os.RemoveAll(filepath.Join(c.dir, "work", info.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	err = filepath.Walk(filepath.Join(c.dir, "pkgs"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && time.Since(info.ModTime()) > maxAge {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// key returns the cache key of files of the package in dir, instrumented to path. deps are the
+// keys of the packages they import.
+func (c *cache) key(dir, path string, files, deps []string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintln(h, c.identity, dir, path)
+	sorted := append([]string(nil), files...)
+	sort.Strings(sorted)
+	for _, file := range sorted {
+		fmt.Fprintln(h, file)
+		if err := hashFile(h, file); err != nil {
+			return "", err
+		}
+	}
+	for _, dep := range deps {
+		fmt.Fprintln(h, dep)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cacheKey returns the cache key of files of i, instrumented to path, where deps are the keys of
+// the packages they import, see uninstrumentedKeys. Patches of a package may import any package
+// of its module, or of GOPATH outside a module, so the names of those packages are part of the
+// key, as are go.mod and go.sum, which decide the versions of the packages outside the module.
+func (i *Instrumentable) cacheKey(path string, files, deps []string) (string, error) {
+	deps = append([]string{i.pkg.ImportPath, i.name}, deps...)
+	dir := i.pkg.Dir
+	if i.module != nil {
+		dir = i.module.Dir
+		files = append([]string{filepath.Join(i.module.Dir, "go.mod")}, files...)
+		gosum := filepath.Join(i.module.Dir, "go.sum")
+		if _, err := os.Stat(gosum); err == nil {
+			files = append(files, gosum)
+		}
+	}
+	if dir == "" {
+		// a package of files is not in a module, see ImportFiles
+		dir = "."
+	}
+	local, err := imports.LocalPackages(dir)
+	if err != nil {
+		return "", err
+	}
+	pkgs := []string{}
+	for name, candidates := range local {
+		for _, pkg := range candidates {
+			pkgs = append(pkgs, name+" "+pkg.ImportPath)
+		}
+	}
+	sort.Strings(pkgs)
+	deps = append(deps, pkgs...)
+	return i.cache.key(i.pkg.Dir, path, files, deps)
+}
+
+func (c *cache) entry(key string) string {
+	return filepath.Join(c.dir, "pkgs", key[:2], key+".json")
+}
+
+// get returns the instrumented files cached with key
+func (c *cache) get(key string) ([]cachedFile, bool) {
+	b, err := ioutil.ReadFile(c.entry(key))
+	if err != nil {
+		return nil, false
+	}
+	var files []cachedFile
+	if err := json.Unmarshal(b, &files); err != nil {
+		return nil, false
+	}
+	c.use(c.entry(key))
+	return files, true
+}
+
+// put caches the instrumented files with key. The entry is renamed into place, so a concurrent
+// get never reads a partial entry.
+func (c *cache) put(key string, files []cachedFile) error {
+	b, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	entry := c.entry(key)
+	if err := os.MkdirAll(filepath.Dir(entry), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(entry), key)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), entry)
+}
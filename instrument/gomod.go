@@ -0,0 +1,119 @@
+package instrument
+
+import (
+	"errors"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/elazarl/gosloppy/imports"
+)
+
+// goModule is a Go module, as described by a go.mod file
+type goModule struct {
+	// Dir is the directory containing go.mod
+	Dir string
+	// Path is the module path, declared in go.mod's module directive
+	Path string
+}
+
+// findModule looks for a go.mod file in dir or any of its parents, see imports.FindModule. It
+// returns nil if dir is not in a module, or if modules are explicitly turned off with
+// GO111MODULE=off.
+func findModule(dir string) (*goModule, error) {
+	root, modpath, err := imports.FindModule(dir)
+	if err != nil || root == "" {
+		return nil, err
+	}
+	return &goModule{root, modpath}, nil
+}
+
+// contains returns whether importpath is a package of module m
+func (m *goModule) contains(importpath string) bool {
+	return hasPathPrefix(importpath, m.Path)
+}
+
+// rel returns the path of dir relative to the module root
+func (m *goModule) rel(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(m.Dir, dir)
+}
+
+// importPath returns the import path of the package at directory dir
+func (m *goModule) importPath(dir string) (string, error) {
+	rel, err := m.rel(dir)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New(dir + " is outside module " + m.Path)
+	}
+	return path.Join(m.Path, filepath.ToSlash(rel)), nil
+}
+
+// importPkg gives an Instrumentable for the in-module package pkgname. pkgname is either an
+// import path in the module, or a local path such as "./cmd/foo".
+// An empty basepkg defaults to the module path, so all in-module dependencies are instrumented.
+func (m *goModule) importPkg(basepkg, pkgname string) (*Instrumentable, error) {
+	var dir, importpath string
+	if build.IsLocalImport(pkgname) || filepath.IsAbs(pkgname) {
+		var err error
+		if dir, err = filepath.Abs(pkgname); err != nil {
+			return nil, err
+		}
+		if importpath, err = m.importPath(dir); err != nil {
+			return nil, err
+		}
+	} else {
+		if !m.contains(pkgname) {
+			return nil, errors.New("package " + pkgname + " is not in module " + m.Path)
+		}
+		importpath = pkgname
+		dir = filepath.Join(m.Dir, filepath.FromSlash(strings.TrimPrefix(pkgname[len(m.Path):], "/")))
+	}
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	pkg.ImportPath = importpath
+	if basepkg == "" {
+		basepkg = m.Path
+	}
+	return newInstrumentable(pkg, basepkg, importpath, m), nil
+}
+
+// copyModFiles copies go.mod and go.sum into outdir, which will be the root of the instrumented
+// module. Relative paths in replace directives are made absolute, so they keep pointing at
+// the original directories.
+func (m *goModule) copyModFiles(outdir string) error {
+	gomod, err := ioutil.ReadFile(filepath.Join(m.Dir, "go.mod"))
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(gomod), "\n")
+	for i, line := range lines {
+		arrow := strings.Index(line, "=>")
+		if arrow < 0 {
+			continue
+		}
+		target := strings.Fields(line[arrow+2:])
+		if len(target) == 0 || !build.IsLocalImport(target[0]) {
+			continue
+		}
+		abs := filepath.Join(m.Dir, target[0])
+		lines[i] = line[:arrow+2] + " " + abs + strings.TrimPrefix(strings.TrimLeft(line[arrow+2:], " \t"), target[0])
+	}
+	if err := ioutil.WriteFile(filepath.Join(outdir, "go.mod"), []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(m.Dir, "go.sum")); os.IsNotExist(err) {
+		return nil
+	}
+	return cp(filepath.Join(outdir, "go.sum"), filepath.Join(m.Dir, "go.sum"))
+}
@@ -1,12 +1,19 @@
 package instrument
 
 import (
+	"bytes"
+	"fmt"
 	"go/build"
+	"go/parser"
+	"go/token"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/elazarl/gosloppy/patch"
 )
@@ -19,6 +26,23 @@ type Instrumentable struct {
 	name             string
 	InstrumentGoroot bool
 	gorootPkgs       map[string]bool
+	// module is the Go module the package belongs to, nil for GOPATH packages
+	module *goModule
+	// sources maps instrumented files to the original files they were generated from,
+	// it is shared between all packages instrumented together
+	sources map[string]string
+	// sourcemaps maps instrumented files to their source maps
+	sourcemaps map[string]*patch.SourceMap
+	// cache is the persistent cache of instrumented packages, nil if they are not cached
+	cache *cache
+	// Parallel is the number of packages InstrumentTo instruments at once, and of files it parses
+	// at once, runtime.NumCPU() if not set
+	Parallel int
+}
+
+func newInstrumentable(pkg *build.Package, basepkg, name string, module *goModule) *Instrumentable {
+	return &Instrumentable{pkg, basepkg, name, false, make(map[string]bool), module, make(map[string]string),
+		make(map[string]*patch.SourceMap), nil, 0}
 }
 
 // Files will give all .go files of a go pacakge
@@ -94,25 +118,57 @@ func Import(basepkg, pkgname string) (*Instrumentable, error) {
 	if basepkg == "" {
 		basepkg = guessBasepkg(pkg.ImportPath)
 	}
-	return &Instrumentable{pkg, basepkg, pkgname, false, make(map[string]bool)}, nil
+	return newInstrumentable(pkg, basepkg, pkgname, nil), nil
 }
 
 func ImportFiles(basepkg string, files ...string) *Instrumentable {
-	return &Instrumentable{&build.Package{GoFiles: files}, basepkg, "", false, make(map[string]bool)}
+	return newInstrumentable(&build.Package{GoFiles: files}, basepkg, "", nil)
 }
 
 // ImportDir gives a single instrumentable golang package. See Import.
+// If the directory is part of a Go module (i.e. it or one of its parents has a go.mod file),
+// the package is imported as part of the module, see ImportModule.
 func ImportDir(basepkg, pkgname string) (*Instrumentable, error) {
+	mod, err := findModule(pkgname)
+	if err != nil {
+		return nil, err
+	}
+	if mod != nil {
+		dir, err := filepath.Abs(pkgname)
+		if err != nil {
+			return nil, err
+		}
+		return mod.importPkg(basepkg, dir)
+	}
 	pkg, err := build.ImportDir(pkgname, 0)
 	if err != nil {
 		return nil, err
 	}
-	return &Instrumentable{pkg, basepkg, pkgname, false, make(map[string]bool)}, nil
+	return newInstrumentable(pkg, basepkg, pkgname, nil), nil
+}
+
+// ImportModule gives an Instrumentable for a package in the Go module containing the
+// current directory. pkgname is either a local path (e.g. "./cmd/foo") or an import path
+// within the module. The module path is the default basepkg, so every in-module package
+// pkgname depends on will be instrumented as well.
+// It returns nil, nil if the current directory is not in a module.
+func ImportModule(basepkg, pkgname string) (*Instrumentable, error) {
+	mod, err := findModule(".")
+	if mod == nil || err != nil {
+		return nil, err
+	}
+	return mod.importPkg(basepkg, pkgname)
 }
 
 // IsInGopath returns whether the Instrumentable is a package in a standalone directory or in GOPATH
 func (i *Instrumentable) IsInGopath() bool {
-	return i.pkg.ImportPath != "." && !i.pkg.Goroot
+	// a bunch of files, as in go run a.go, have no import path
+	return i.pkg.ImportPath != "." && i.pkg.ImportPath != "" && !i.pkg.Goroot && i.module == nil
+}
+
+// IsInModule returns whether the Instrumentable is a package of a Go module
+func (i *Instrumentable) IsInModule() bool {
+	return i.module != nil
 }
 
 // relevantImport will determine whether this import should be instrumented as well
@@ -122,38 +178,118 @@ func (i *Instrumentable) relevantImport(imp string) bool {
 		return false
 	case i.gorootPkgs[imp] && !i.InstrumentGoroot:
 		return false
+	case i.module != nil && !build.IsLocalImport(imp):
+		return i.module.contains(imp) && (i.basepkg == "*" || hasPathPrefix(imp, i.basepkg))
 	case i.basepkg == "*" || build.IsLocalImport(imp):
 		return true
 	case i.IsInGopath() || i.basepkg != "":
-		return filepath.HasPrefix(imp, i.basepkg) || filepath.HasPrefix(i.basepkg, imp)
+		return hasPathPrefix(imp, i.basepkg) || hasPathPrefix(i.basepkg, imp)
 	}
 	return false
 }
 
+// hasPathPrefix returns whether the import path p is prefix, or a package below it. Unlike
+// strings.HasPrefix, example.com/mm does not have the prefix example.com/m
+func hasPathPrefix(p, prefix string) bool {
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
 func (i *Instrumentable) doimport(pkg string) (*Instrumentable, error) {
 	if build.IsLocalImport(pkg) {
-		return ImportDir(i.basepkg, filepath.Join(i.pkg.Dir, pkg))
+		r, err := ImportDir(i.basepkg, filepath.Join(i.pkg.Dir, pkg))
+		if err != nil {
+			return r, err
+		}
+		r.sources = i.sources
+		r.sourcemaps = i.sourcemaps
+		r.cache = i.cache
+		return r, nil
+	}
+	var r *Instrumentable
+	var err error
+	if i.module != nil && i.module.contains(pkg) {
+		r, err = i.module.importPkg(i.basepkg, pkg)
+	} else {
+		// TODO: A bit hackish
+		r, err = Import(i.basepkg, pkg)
 	}
-	// TODO: A bit hackish
-	r, err := Import(i.basepkg, pkg)
 	if err != nil {
 		return r, err
 	}
 	r.name = i.name
 	r.gorootPkgs = i.gorootPkgs
+	r.sources = i.sources
+	r.sourcemaps = i.sourcemaps
+	r.cache = i.cache
 	r.InstrumentGoroot = i.InstrumentGoroot
 	return r, nil
 }
 
+// Packages returns the identifiers of all packages that would be instrumented, i.e. i itself
+// and every relevant package it imports. Packages are given by import path, or by directory
+// if they are not in GOPATH. Dependencies are listed before packages importing them.
+func (i *Instrumentable) Packages(withtests bool) ([]string, error) {
+	var pkgs []string
+	if err := i.packages(make(map[string]bool), withtests, &pkgs); err != nil {
+		return nil, err
+	}
+	return pkgs, nil
+}
+
+func (i *Instrumentable) packages(processed map[string]bool, withtests bool, pkgs *[]string) error {
+	if processed[i.id()] {
+		return nil
+	}
+	processed[i.id()] = true
+	imps := i.pkg.Imports
+	if withtests {
+		imps = append(imps, i.pkg.TestImports...)
+		imps = append(imps, i.pkg.XTestImports...)
+	}
+	for _, imp := range imps {
+		if i.relevantImport(imp) {
+			pkg, err := i.doimport(imp)
+			if err != nil {
+				return err
+			}
+			if err := pkg.packages(processed, false, pkgs); err != nil {
+				return err
+			}
+		}
+	}
+	*pkgs = append(*pkgs, i.id())
+	return nil
+}
+
 var tempStem = "__instrument.go"
 
+// Instrument instruments i into a new temporary directory, see InstrumentTo. Packages cached with
+// UseCache are always instrumented into the same directory, under the cache directory, where
+// only files that changed since the last time are written.
 func (i *Instrumentable) Instrument(withtests bool, f func(file *patch.PatchableFile) patch.Patches) (pkgdir string, hasGoroot bool, err error) {
-	d, err := ioutil.TempDir(os.TempDir(), tempStem)
+	return InstrumentPackages([]*Instrumentable{i}, withtests, f)
+}
+
+// InstrumentPackages instruments pkgs into a single new temporary directory, see
+// InstrumentPackagesTo, or with a cache, into the same directory as before, see Instrument.
+func InstrumentPackages(pkgs []*Instrumentable, withtests bool, f func(file *patch.PatchableFile) patch.Patches) (dir string, hasGoroot bool, err error) {
+	if c := pkgs[0].cache; c != nil {
+		ids := []string{}
+		for _, pkg := range pkgs {
+			ids = append(ids, pkg.id())
+		}
+		dir = c.workdir(strings.Join(ids, ","))
+		if err = os.MkdirAll(dir, 0755); err == nil {
+			err = c.use(dir)
+		}
+	} else {
+		dir, err = ioutil.TempDir(os.TempDir(), tempStem)
+	}
 	if err != nil {
 		return "", false, err
 	}
-	hasGoroot, err = i.InstrumentTo(withtests, d, f)
-	return d, hasGoroot, err
+	hasGoroot, err = InstrumentPackagesTo(pkgs, withtests, dir, f)
+	return dir, hasGoroot, err
 }
 
 func (i *Instrumentable) InstrumentInline(f func(file *patch.PatchableFile) patch.Patches) error {
@@ -162,7 +298,7 @@ func (i *Instrumentable) InstrumentInline(f func(file *patch.PatchableFile) patc
 
 // returns a string that identifies the package
 func (i *Instrumentable) id() string {
-	if i.pkg.ImportPath == "" {
+	if i.pkg.ImportPath == "" || i.pkg.ImportPath == "." {
 		if i.pkg.Dir == "" {
 			// It's just a bunch of files
 			return strings.Join(i.pkg.GoFiles, ",")
@@ -200,6 +336,9 @@ func (i *Instrumentable) instrumentInline(processed map[string]bool, f func(file
 	}
 	for path, file := range pkg.Files {
 		patches := f(file)
+		if len(patches) == 0 {
+			continue
+		}
 		outfile, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0644)
 		if err != nil {
 			return err
@@ -207,6 +346,10 @@ func (i *Instrumentable) instrumentInline(processed map[string]bool, f func(file
 		if _, err := file.FprintPatched(outfile, file.All(), patches); err != nil {
 			return err
 		}
+		// All() ends at the last token, keep the trailing newline
+		if _, err := io.WriteString(outfile, file.Orig[file.Fset.Position(file.All().End()).Offset:]); err != nil {
+			return err
+		}
 		if err := outfile.Close(); err != nil {
 			return err
 		}
@@ -214,31 +357,24 @@ func (i *Instrumentable) instrumentInline(processed map[string]bool, f func(file
 	return nil
 }
 
-// InstrumentTo will instrument all files in Instrumentable into outdir. It will instrument all subpackages
-// as described in Import.
-func (i *Instrumentable) InstrumentTo(withtests bool, outdir string,
-	f func(file *patch.PatchableFile) patch.Patches) (hasGoroot bool, err error) {
-	if err := i.instrumentTo(map[string]bool{}, withtests, outdir, "", f); err != nil {
-		return false, err
-	}
-	hasGoroot = len(i.gorootPkgs) > 0 && i.InstrumentGoroot
-	if hasGoroot {
-		if err := symlinkGoroot(filepath.Join(outdir, "goroot")); err != nil {
-			return false, err
-		}
-	}
-	return hasGoroot, nil
+// InstrumentPrint writes the instrumented files of i, and of all packages instrumented with it,
+// to w, without changing anything on disk. If diff is set, a unified diff against the original
+// files is written instead.
+func (i *Instrumentable) InstrumentPrint(w io.Writer, withtests, diff bool, f func(file *patch.PatchableFile) patch.Patches) error {
+	return i.instrumentPrint(make(map[string]bool), w, withtests, diff, f)
 }
 
-func (i *Instrumentable) instrumentTo(processed map[string]bool, istest bool, outdir, relpath string, f func(file *patch.PatchableFile) patch.Patches) error {
-	if processed[relpath] {
+func (i *Instrumentable) instrumentPrint(processed map[string]bool, w io.Writer, withtests, diff bool, f func(file *patch.PatchableFile) patch.Patches) error {
+	if processed[i.id()] {
 		return nil
 	}
-	processed[i.pkg.ImportPath] = true
+	processed[i.id()] = true
 	imps := i.pkg.Imports
-	if istest {
+	files := i.Files()
+	if withtests {
 		imps = append(imps, i.pkg.TestImports...)
 		imps = append(imps, i.pkg.XTestImports...)
+		files = append(i.TestFiles(), i.XTestFiles()...)
 	}
 	for _, imp := range imps {
 		if i.relevantImport(imp) {
@@ -246,41 +382,254 @@ func (i *Instrumentable) instrumentTo(processed map[string]bool, istest bool, ou
 			if err != nil {
 				return err
 			}
-			if build.IsLocalImport(imp) {
-				imp = "./" + filepath.Join(relpath, imp)
-			}
-			if err := pkg.instrumentTo(processed, false, outdir, imp, f); err != nil {
+			if err := pkg.instrumentPrint(processed, w, false, diff, f); err != nil {
 				return err
 			}
 		}
 	}
-	if !istest {
-		pkg := patch.NewPatchablePkg()
-		if err := pkg.ParseFiles(i.Files()...); err != nil {
+	for _, path := range files {
+		// test files and external test files are in two different packages
+		file, err := patch.ParsePatchable(path)
+		if err != nil {
 			return err
 		}
-		if err := i.instrumentPatchable(outdir, relpath, pkg, f); err != nil {
-			return err
+		patches := f(file)
+		if diff {
+			if err := file.FprintDiff(w, patches); err != nil {
+				return err
+			}
+			continue
 		}
-	} else {
-		pkg := patch.NewPatchablePkg()
-		if err := pkg.ParseFiles(i.TestFiles()...); err != nil {
+		if _, err := fmt.Fprintf(w, "// %s\n", path); err != nil {
 			return err
 		}
-		if err := i.instrumentPatchable(outdir, relpath, pkg, f); err != nil {
+		if _, err := file.FprintPatched(w, file.All(), patches); err != nil {
 			return err
 		}
-		pkg = patch.NewPatchablePkg()
-		if err := pkg.ParseFiles(i.XTestFiles()...); err != nil {
+		if _, err := io.WriteString(w, "\n"); err != nil {
 			return err
 		}
-		if err := i.instrumentPatchable(outdir, relpath, pkg, f); err != nil {
-			return err
+	}
+	return nil
+}
+
+// InstrumentTo will instrument all files in Instrumentable into outdir. It will instrument all subpackages
+// as described in Import.
+// Sources instrumented into outdir before, which are no longer instrumented, are removed.
+// Packages are instrumented concurrently, up to Parallel at once, so f may be called concurrently
+// for files of different packages, and should print to Output. Files of a single package share
+// its scope, and are patched one after another. Neither the instrumented files nor the error
+// returned, which is that of the first package to fail in import order, depend on timing.
+func (i *Instrumentable) InstrumentTo(withtests bool, outdir string,
+	f func(file *patch.PatchableFile) patch.Patches) (hasGoroot bool, err error) {
+	return InstrumentPackagesTo([]*Instrumentable{i}, withtests, outdir, f)
+}
+
+// InstrumentPackagesTo instruments pkgs into outdir, like InstrumentTo. The packages they import
+// are instrumented once, as are those among pkgs that others import. With tests, the tests of
+// every package of pkgs are instrumented. The packages must all be in a single module, or in
+// GOPATH, where they are instrumented by import path, as none is the root of outdir.
+func InstrumentPackagesTo(pkgs []*Instrumentable, withtests bool, outdir string,
+	f func(file *patch.PatchableFile) patch.Patches) (hasGoroot bool, err error) {
+	in := newInstrumentation(outdir, pkgs[0].Parallel, f)
+	for _, pkg := range pkgs[1:] {
+		pkg.sources, pkg.sourcemaps, pkg.gorootPkgs = pkgs[0].sources, pkgs[0].sourcemaps, pkgs[0].gorootPkgs
+	}
+	for _, pkg := range pkgs {
+		if len(pkgs) > 1 {
+			pkg.name = ""
+			in.tests[pkg.pkg.ImportPath] = withtests
+		}
+	}
+	errs := make([]error, len(pkgs))
+	var wg sync.WaitGroup
+	for n, pkg := range pkgs {
+		wg.Add(1)
+		go func(n int, pkg *Instrumentable) {
+			defer wg.Done()
+			errs[n] = pkg.instrumentTo(in, nil, withtests, rootPath(pkgs, pkg))
+		}(n, pkg)
+	}
+	wg.Wait()
+	in.xtests.Wait()
+	in.flush(os.Stderr)
+	for _, err := range append(errs, in.xtestErr()) {
+		if err != nil {
+			return false, err
+		}
+	}
+	if err := in.removeStale(); err != nil {
+		return false, err
+	}
+	if i := pkgs[0]; i.module != nil {
+		if err := i.module.copyModFiles(outdir); err != nil {
+			return false, err
 		}
 	}
+	hasGoroot = len(pkgs[0].gorootPkgs) > 0 && pkgs[0].InstrumentGoroot
+	if hasGoroot {
+		if err := symlinkGoroot(filepath.Join(outdir, "goroot")); err != nil {
+			return false, err
+		}
+	}
+	return hasGoroot, nil
+}
+
+// rootPath returns the relative path pkg, one of pkgs, is instrumented with. A single package is
+// the root of the output directory, otherwise packages are instrumented as if imported.
+func rootPath(pkgs []*Instrumentable, pkg *Instrumentable) string {
+	if len(pkgs) == 1 {
+		return ""
+	}
+	return pkg.pkg.ImportPath
+}
+
+// instrumentTo instruments i, and the relevant packages it imports, concurrently. parents are
+// the packages being instrumented that i was imported from. The external test package of i is
+// instrumented once i is, and nothing waits for it, as it may import packages importing i.
+func (i *Instrumentable) instrumentTo(in *instrumentation, parents []*instrumented, istest bool, relpath string) error {
+	istest = istest || in.tests[i.pkg.ImportPath]
+	self, ok := in.start(relpath, i.pkg.ImportPath)
+	if ok {
+		for _, parent := range parents {
+			if parent == self {
+				// an external test package importing the package it tests
+				return nil
+			}
+		}
+		<-self.done
+		return self.err
+	}
+	defer close(self.done)
+	parents = append(append([]*instrumented(nil), parents...), self)
+	imps, files := i.pkg.Imports, i.Files()
+	if istest {
+		imps, files = append(append([]string(nil), imps...), i.pkg.TestImports...), i.TestFiles()
+	}
+	key, err := i.instrumentPkg(in, parents, relpath, imps, files)
+	if err != nil {
+		self.err = err
+		return err
+	}
+	if i.cache != nil {
+		in.mu.Lock()
+		i.cache.keys[i.id()] = key
+		in.mu.Unlock()
+	}
+	if istest && len(i.pkg.XTestGoFiles) > 0 {
+		in.xtests.Add(1)
+		go func() {
+			defer in.xtests.Done()
+			_, err := i.instrumentPkg(in, nil, relpath, i.pkg.XTestImports, i.XTestFiles())
+			in.mu.Lock()
+			in.xtestErrs[relpath] = err
+			in.mu.Unlock()
+		}()
+	}
 	return nil
 }
 
+// instrumentPkg instruments the relevant packages of imps, then files, which import them, and
+// then the packages the patches import. It returns the cache key of the files.
+func (i *Instrumentable) instrumentPkg(in *instrumentation, parents []*instrumented, relpath string, imps, files []string) (key string, err error) {
+	pkgs, err := i.instrumentImports(in, parents, relpath, imps)
+	if err != nil {
+		return "", err
+	}
+	deps := []string{}
+	if i.cache != nil {
+		in.mu.Lock()
+		for _, pkg := range pkgs {
+			deps = append(deps, i.cache.keys[pkg.id()])
+		}
+		in.mu.Unlock()
+		keys, err := i.uninstrumentedKeys(in, imps)
+		if err != nil {
+			return "", err
+		}
+		deps = append(deps, keys...)
+	}
+	in.workers <- struct{}{}
+	key, added, err := i.instrumentFiles(in, relpath, files, deps)
+	<-in.workers
+	if err != nil {
+		return "", err
+	}
+	// the packages the patches import, for example by auto import, are instrumented as well
+	_, err = i.instrumentImports(in, parents, relpath, added)
+	return key, err
+}
+
+// uninstrumentedKeys returns the cache keys of the original files of the packages of imps which
+// are not instrumented. Patches depend on their declarations as well, must is rewritten by the
+// number of results of its argument. The standard library changes only with the go version, and
+// packages outside the module with its go.mod and go.sum, so they are not imported.
+func (i *Instrumentable) uninstrumentedKeys(in *instrumentation, imps []string) ([]string, error) {
+	keys := []string{}
+	for _, imp := range imps {
+		in.mu.Lock()
+		skip := imp == "C" || i.relevantImport(imp) || i.gorootPkgs[imp] ||
+			i.module != nil && !build.IsLocalImport(imp) && !i.module.contains(imp)
+		in.mu.Unlock()
+		if skip {
+			continue
+		}
+		pkg, err := i.doimport(imp)
+		if err != nil || pkg.pkg.Goroot {
+			// the go tool reports packages that are missing
+			continue
+		}
+		files := []string{}
+		for _, file := range append(pkg.pkg.GoFiles, pkg.pkg.CgoFiles...) {
+			files = append(files, filepath.Join(pkg.pkg.Dir, file))
+		}
+		key, err := i.cache.key(pkg.pkg.Dir, "", files, nil)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// instrumentImports instruments the relevant packages of imps concurrently. It returns them in
+// the order of imps, or the error of the first that failed.
+func (i *Instrumentable) instrumentImports(in *instrumentation, parents []*instrumented, relpath string, imps []string) ([]*Instrumentable, error) {
+	pkgs := make([]*Instrumentable, len(imps))
+	errs := make([]error, len(imps))
+	var wg sync.WaitGroup
+	for n, imp := range imps {
+		in.mu.Lock()
+		relevant := i.relevantImport(imp)
+		in.mu.Unlock()
+		if !relevant {
+			continue
+		}
+		if pkgs[n], errs[n] = i.doimport(imp); errs[n] != nil {
+			continue
+		}
+		if build.IsLocalImport(imp) {
+			imp = "./" + filepath.Join(relpath, imp)
+		}
+		wg.Add(1)
+		go func(n int, imp string) {
+			defer wg.Done()
+			errs[n] = pkgs[n].instrumentTo(in, parents, false, imp)
+		}(n, imp)
+	}
+	wg.Wait()
+	relevant := []*Instrumentable{}
+	for n := range imps {
+		if errs[n] != nil {
+			return nil, errs[n]
+		}
+		if pkgs[n] != nil {
+			relevant = append(relevant, pkgs[n])
+		}
+	}
+	return relevant, nil
+}
+
 func cp(dst, src string) error {
 	s, err := os.Open(src)
 	if err != nil {
@@ -298,69 +647,193 @@ func cp(dst, src string) error {
 	return d.Close()
 }
 
-func (i *Instrumentable) instrumentPatchable(outdir, relpath string, pkg *patch.PatchablePkg, f func(file *patch.PatchableFile) patch.Patches) error {
-	path := ""
-	if build.IsLocalImport(relpath) {
-		path = strings.Replace(relpath, "..", "__", -1)
-		path = filepath.Join("locals", path)
-	} else if i.pkg.Goroot {
-		path = filepath.Join("goroot", "src", "pkg", i.pkg.ImportPath)
-		i.gorootPkgs[i.pkg.ImportPath] = true
-	} else if relpath != "" {
-		path = filepath.Join("gopath", i.pkg.ImportPath)
+// instrumentFiles instruments files of i into the output directory, and returns their cache key
+// and the packages the patches import, for example by auto import, which are not among the
+// imports of the original package. deps are the cache keys of the packages the files import. If
+// the files were instrumented before, with the same dependencies, the instrumented files are
+// taken from the cache.
+func (i *Instrumentable) instrumentFiles(in *instrumentation, relpath string, files, deps []string) (key string, added []string, err error) {
+	in.mu.Lock()
+	path, err := i.outputPath(relpath)
+	in.mu.Unlock()
+	if err != nil {
+		return "", nil, err
 	}
-	if err := os.MkdirAll(filepath.Join(outdir, path), 0755); err != nil {
-		return err
+	if err := os.MkdirAll(filepath.Join(in.outdir, path), 0755); err != nil {
+		return "", nil, err
 	}
 	// copy all none-go files (TODO: symlink? OTOH you wouldn't have standalone package)
 	for _, files := range [][]string{i.pkg.CFiles, i.pkg.HFiles, i.pkg.SFiles, i.pkg.SysoFiles} {
 		for _, file := range files {
-			if err := cp(filepath.Join(outdir, path, file), filepath.Join(i.pkg.Dir, file)); err != nil {
-				return err
+			if err := cp(filepath.Join(in.outdir, path, file), filepath.Join(i.pkg.Dir, file)); err != nil {
+				return "", nil, err
 			}
 		}
 	}
-	for filename, file := range pkg.Files {
-		if outfile, err := os.Create(filepath.Join(outdir, path, filepath.Base(filename))); err != nil {
-			return err
-		} else {
-			patches := f(file)
-			// TODO(elazar): check the relative path from current location (aka relpath, path), to the import path
-			// (aka v)
-			for _, imp := range file.File.Imports {
-				switch v := imp.Path.Value[1 : len(imp.Path.Value)-1]; {
-				case v == i.pkg.ImportPath:
-					patches = appendNoContradict(patches, patch.Replace(imp.Path, `"."`))
-				case !i.relevantImport(v) || i.gorootPkgs[v]:
-					continue
-				case build.IsLocalImport(v):
-					rel, err := filepath.Rel(path, filepath.Join("locals", v))
-					if err != nil {
-						return err
-					}
-					patches = appendNoContradict(patches, patch.Replace(imp.Path, `"./`+rel+`"`))
-				default:
-					if v == i.name {
-						v = ""
-					} else {
-						v = filepath.Join("gopath", v)
-					}
-					rel, err := filepath.Rel(path, v)
-					if err != nil {
-						return err
-					}
-					patches = appendNoContradict(patches, patch.Replace(imp.Path, `"./`+rel+`"`))
-				}
+	instrumented, cached := []cachedFile(nil), false
+	if i.cache != nil {
+		if key, err = i.cacheKey(path, files, deps); err != nil {
+			return "", nil, err
+		}
+		instrumented, cached = i.cache.get(key)
+	}
+	if cached {
+		// the files are not patched again, but the errors found patching them still apply
+		in.mu.Lock()
+		for _, file := range instrumented {
+			in.output[file.Name] = bytes.NewBuffer(file.Output)
+		}
+		in.mu.Unlock()
+	}
+	if !cached {
+		pkg := patch.NewPatchablePkg()
+		if err := pkg.ParseFilesParallel(cap(in.workers), files...); err != nil {
+			return "", nil, err
+		}
+		if instrumented, err = i.instrumentPatchable(in, path, pkg); err != nil {
+			return "", nil, err
+		}
+		if i.cache != nil {
+			// a package we failed to cache is simply instrumented again next time
+			i.cache.put(key, instrumented)
+		}
+	}
+	for _, file := range instrumented {
+		outname := filepath.Join(in.outdir, path, filepath.Base(file.Name))
+		if err := in.writeFile(outname, file.Content); err != nil {
+			return "", nil, err
+		}
+		if abs, err := filepath.Abs(outname); err == nil {
+			in.mu.Lock()
+			if orig, err := filepath.Abs(file.Name); err == nil {
+				i.sources[abs] = orig
 			}
-			if _, err := file.FprintPatched(outfile, file.All(), patches); err != nil {
-				return err
+			i.sourcemaps[abs] = file.SourceMap
+			in.mu.Unlock()
+		}
+		added = append(added, file.Added...)
+	}
+	return key, added, nil
+}
+
+// outputPath returns the directory, relative to the output directory, i is instrumented to
+func (i *Instrumentable) outputPath(relpath string) (string, error) {
+	switch {
+	case i.module != nil:
+		// module packages keep their place relative to go.mod, so imports need no rewriting
+		return i.module.rel(i.pkg.Dir)
+	case build.IsLocalImport(relpath):
+		return filepath.Join("locals", strings.Replace(relpath, "..", "__", -1)), nil
+	case i.pkg.Goroot:
+		i.gorootPkgs[i.pkg.ImportPath] = true
+		return filepath.Join("goroot", "src", "pkg", i.pkg.ImportPath), nil
+	case relpath != "":
+		return filepath.Join("gopath", i.pkg.ImportPath), nil
+	}
+	return "", nil
+}
+
+// addedImports returns the import paths of the instrumented source, which file does not import
+func addedImports(file *patch.PatchableFile, instrumented []byte) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), "", instrumented, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+	orig := make(map[string]bool)
+	for _, imp := range file.File.Imports {
+		orig[imp.Path.Value] = true
+	}
+	added := []string{}
+	for _, imp := range f.Imports {
+		if !orig[imp.Path.Value] {
+			if path, err := strconv.Unquote(imp.Path.Value); err == nil {
+				added = append(added, path)
 			}
-			if err := outfile.Close(); err != nil {
-				return err
+		}
+	}
+	return added
+}
+
+// instrumentPatchable returns the patched files of pkg, which is instrumented to path, with the
+// packages the patches import. Those are returned only for packages in a module, in GOPATH they
+// refer to the original package, as the import is not rewritten.
+func (i *Instrumentable) instrumentPatchable(in *instrumentation, path string, pkg *patch.PatchablePkg) (instrumented []cachedFile, err error) {
+	filenames := []string{}
+	for filename := range pkg.Files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		file := pkg.Files[filename]
+		patches := in.patch(file)
+		in.mu.Lock()
+		patches, err = i.rewriteImports(path, file, patches)
+		in.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		buf := new(bytes.Buffer)
+		// patches may add lines, errors should still point to the original lines
+		file.LineDirectives = true
+		sourcemap, err := file.FprintPatchedSourceMap(buf, file.All(), patches)
+		if err != nil {
+			return nil, err
+		}
+		var added []string
+		if i.module != nil {
+			added = addedImports(file, buf.Bytes())
+		}
+		in.mu.Lock()
+		output := in.output[file.FileName].Bytes()
+		in.mu.Unlock()
+		instrumented = append(instrumented, cachedFile{filename, buf.Bytes(), sourcemap, added, output})
+	}
+	return instrumented, nil
+}
+
+// rewriteImports adds patches to the import paths of file, instrumented to path, pointing them at
+// the instrumented packages
+func (i *Instrumentable) rewriteImports(path string, file *patch.PatchableFile, patches patch.Patches) (patch.Patches, error) {
+	// TODO(elazar): check the relative path from current location (aka relpath, path), to the import path
+	// (aka v)
+	for _, imp := range file.File.Imports {
+		switch v := imp.Path.Value[1 : len(imp.Path.Value)-1]; {
+		case i.module != nil && i.module.contains(v):
+			continue
+		case v == i.pkg.ImportPath:
+			patches = appendNoContradict(patches, patch.Replace(imp.Path, `"."`))
+		case !i.relevantImport(v) || i.gorootPkgs[v]:
+			continue
+		case build.IsLocalImport(v):
+			rel, err := filepath.Rel(path, filepath.Join("locals", v))
+			if err != nil {
+				return nil, err
+			}
+			patches = appendNoContradict(patches, patch.Replace(imp.Path, localImport(rel)))
+		default:
+			if v == i.name {
+				v = ""
+			} else {
+				v = filepath.Join("gopath", v)
 			}
+			rel, err := filepath.Rel(path, v)
+			if err != nil {
+				return nil, err
+			}
+			patches = appendNoContradict(patches, patch.Replace(imp.Path, localImport(rel)))
 		}
 	}
-	return nil
+	return patches, nil
+}
+
+// localImport returns the quoted local import path of the relative path rel, which the go tool
+// accepts in canonical form only, e.g. "./a" or "../a"
+func localImport(rel string) string {
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+	return `"` + rel + `"`
 }
 
 func appendNoContradict(patches patch.Patches, toadd patch.Patch) patch.Patches {
@@ -1,6 +1,7 @@
 package instrument
 
 import (
+	"bytes"
 	"fmt"
 	"go/build"
 	"io/ioutil"
@@ -8,7 +9,9 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/elazarl/gosloppy/patch"
 )
@@ -338,6 +341,142 @@ func TestGopathSubDir(t *testing.T) {
 	}()
 }
 
+func TestModule(t *testing.T) {
+	if prev, ok := os.LookupEnv("GO111MODULE"); ok {
+		OrFail(os.Unsetenv("GO111MODULE"), t)
+		defer os.Setenv("GO111MODULE", prev)
+	}
+	fs := dir(
+		"mod",
+		file("go.mod", "module example.com/mod\n\nreplace example.com/other => ../other\n"),
+		file("go.sum", ""),
+		dir("lib", file("lib.go", "package lib")),
+		dir("unused", file("unused.go", "package unused")),
+		dir("cmd", dir("foo", file("main.go", `package main;import "example.com/mod/lib";func main() {}`))),
+	)
+	OrFail(fs.Build("."), t)
+	defer func() { OrFail(os.RemoveAll("mod"), t) }()
+	pkg, err := ImportDir("", "mod/cmd/foo")
+	OrFail(err, t)
+	if !pkg.IsInModule() || pkg.IsInGopath() {
+		t.Fatal("Expected mod/cmd/foo to be in a module")
+	}
+	if pkg.pkg.ImportPath != "example.com/mod/cmd/foo" {
+		t.Fatal("Expected import path example.com/mod/cmd/foo got", pkg.pkg.ImportPath)
+	}
+	OrFail(os.Mkdir("temp", 0755), t)
+	defer func() { OrFail(os.RemoveAll("temp"), t) }()
+	_, err = pkg.InstrumentTo(false, "temp", func(pf *patch.PatchableFile) patch.Patches {
+		return nil
+	})
+	OrFail(err, t)
+	moddir, err := filepath.Abs("mod")
+	OrFail(err, t)
+	dir("temp",
+		file("go.mod", "module example.com/mod\n\nreplace example.com/other => "+filepath.Join(moddir, "../other")+"\n"),
+		file("go.sum", ""),
+		dir("lib", file("lib.go", "package lib")),
+		dir("cmd", dir("foo", file("main.go", `package main;import "example.com/mod/lib";func main() {}`))),
+	).AssertEqual("temp", t)
+}
+
+func TestRelevantImport(t *testing.T) {
+	mod := &goModule{"/tmp/m", "example.com/m"}
+	pkg := newInstrumentable(&build.Package{ImportPath: "example.com/m"}, "example.com/m", "example.com/m", mod)
+	for imp, expected := range map[string]bool{
+		"example.com/m":      true,
+		"example.com/m/sub":  true,
+		"example.com/mm":     false,
+		"example.com/mm/sub": false,
+		"./sub":              true,
+		"C":                  false,
+	} {
+		if pkg.relevantImport(imp) != expected {
+			t.Error("Expected relevantImport of", imp, "to be", expected)
+		}
+	}
+	pkg = newInstrumentable(&build.Package{ImportPath: "a/b"}, "a/b", "a/b", nil)
+	for imp, expected := range map[string]bool{"a/b/c": true, "a": true, "a/bc": false, "a/bc/d": false} {
+		if pkg.relevantImport(imp) != expected {
+			t.Error("Expected relevantImport of", imp, "to be", expected)
+		}
+	}
+}
+
+func TestInstrumentPackages(t *testing.T) {
+	if prev, ok := os.LookupEnv("GO111MODULE"); ok {
+		OrFail(os.Unsetenv("GO111MODULE"), t)
+		defer os.Setenv("GO111MODULE", prev)
+	}
+	// the external test package of a imports b, which imports a
+	fs := dir(
+		"mod",
+		file("go.mod", "module example.com/mod\n"),
+		dir("a", file("a.go", "package a"), file("a_test.go", `package a_test;import "example.com/mod/b"`)),
+		dir("b", file("b.go", `package b;import "example.com/mod/a"`), file("b_test.go", "package b")),
+	)
+	OrFail(fs.Build("."), t)
+	defer func() { OrFail(os.RemoveAll("mod"), t) }()
+	a, err := ImportDir("", "mod/a")
+	OrFail(err, t)
+	b, err := ImportDir("", "mod/b")
+	OrFail(err, t)
+	OrFail(os.Mkdir("temp", 0755), t)
+	defer func() { OrFail(os.RemoveAll("temp"), t) }()
+	_, err = InstrumentPackagesTo([]*Instrumentable{a, b}, true, "temp", func(pf *patch.PatchableFile) patch.Patches {
+		return nil
+	})
+	OrFail(err, t)
+	dir("temp",
+		file("go.mod", "module example.com/mod\n"),
+		dir("a", file("a.go", "package a"), file("a_test.go", `package a_test;import "example.com/mod/b"`)),
+		dir("b", file("b.go", `package b;import "example.com/mod/a"`), file("b_test.go", "package b")),
+	).AssertEqual("temp", t)
+}
+
+func TestPackages(t *testing.T) {
+	fs := dir(
+		"test",
+		dir("sub1", file("sub1.go", "package sub1")),
+		dir("sub2", file("sub2.go", `package sub2;import "../sub1"`)),
+		file("base.go", `package test1;import "./sub2"`), file("a_test.go", `package test1;import "./sub1"`),
+	)
+	OrFail(fs.Build("."), t)
+	defer func() { OrFail(os.RemoveAll("test"), t) }()
+	pkg, err := ImportDir("", "test")
+	OrFail(err, t)
+	pkgs, err := pkg.Packages(false)
+	OrFail(err, t)
+	expectEq("[test/sub1 test/sub2 test]", fmt.Sprint(pkgs), t)
+	pkgs, err = pkg.Packages(true)
+	OrFail(err, t)
+	expectEq("[test/sub1 test/sub2 test]", fmt.Sprint(pkgs), t)
+}
+
+func TestInstrumentPrint(t *testing.T) {
+	fs := dir(
+		"test",
+		dir("sub1", file("sub1.go", "package sub1\n")),
+		file("base.go", `package test1;import "./sub1"`), file("a_test.go", "package test1\n"),
+	)
+	OrFail(fs.Build("."), t)
+	defer func() { OrFail(os.RemoveAll("test"), t) }()
+	pkg, err := ImportDir("", "test")
+	OrFail(err, t)
+	f := func(pf *patch.PatchableFile) patch.Patches {
+		return patch.Patches{patch.Insert(pf.File.Name.End(), ";var x = 1")}
+	}
+	buf := new(bytes.Buffer)
+	OrFail(pkg.InstrumentPrint(buf, false, false, f), t)
+	expectEq("// test/sub1/sub1.go\npackage sub1;var x = 1\n// test/base.go\npackage test1;var x = 1;import \"./sub1\"\n", buf.String(), t)
+	buf.Reset()
+	OrFail(pkg.InstrumentPrint(buf, true, true, f), t)
+	expectEq("--- test/sub1/sub1.go\n+++ test/sub1/sub1.go\n@@ -1 +1 @@\n-package sub1\n+package sub1;var x = 1\n"+
+		"--- test/base.go\n+++ test/base.go\n@@ -1 +1 @@\n-package test1;import \"./sub1\"\n\\ No newline at end of file\n"+
+		"+package test1;var x = 1;import \"./sub1\"\n\\ No newline at end of file\n"+
+		"--- test/a_test.go\n+++ test/a_test.go\n@@ -1 +1 @@\n-package test1\n+package test1;var x = 1\n", buf.String(), t)
+}
+
 func TestInline(t *testing.T) {
 	OrFail(dir("temp", file("a.go", "package main;func main() {println(`bobo`)}")).Build("."), t)
 	defer os.RemoveAll("temp")
@@ -349,6 +488,223 @@ func TestInline(t *testing.T) {
 	dir("temp", file("a.go", "koko")).AssertEqual("temp", t)
 }
 
+func TestFiles(t *testing.T) {
+	OrFail(dir("test", file("a.go", `package main;import "time"`)).Build("."), t)
+	defer func() { OrFail(os.RemoveAll("test"), t) }()
+	pkg := ImportFiles("", "test/a.go")
+	OrFail(os.Mkdir("temp", 0755), t)
+	defer func() { OrFail(os.RemoveAll("temp"), t) }()
+	_, err := pkg.InstrumentTo(false, "temp", func(pf *patch.PatchableFile) patch.Patches { return nil })
+	OrFail(err, t)
+	// the standard library is not instrumented
+	dir("temp", file("a.go", `package main;import "time"`)).AssertEqual("temp", t)
+}
+
+func TestCache(t *testing.T) {
+	OrFail(dir("test",
+		dir("sub", file("sub.go", "package sub")),
+		file("a.go", `package test;import "./sub"`),
+	).Build("."), t)
+	defer func() { OrFail(os.RemoveAll("test"), t) }()
+	cachedir, err := ioutil.TempDir("", "cache")
+	OrFail(err, t)
+	defer func() { OrFail(os.RemoveAll(cachedir), t) }()
+	c := &cache{cachedir, "test", make(map[string]string)}
+	patched := []string{}
+	instrument := func() {
+		pkg, err := ImportDir(".", "test")
+		OrFail(err, t)
+		pkg.cache = c
+		OrFail(os.Mkdir("temp", 0755), t)
+		defer func() { OrFail(os.RemoveAll("temp"), t) }()
+		_, err = pkg.InstrumentTo(false, "temp", func(pf *patch.PatchableFile) patch.Patches {
+			patched = append(patched, filepath.Base(pf.Fset.Position(pf.File.Pos()).Filename))
+			return patch.Patches{patch.Insert(pf.File.Name.End(), ";var koko int")}
+		})
+		OrFail(err, t)
+		sub, err := ioutil.ReadFile("test/sub/sub.go")
+		OrFail(err, t)
+		dir("temp",
+			dir("locals", dir("sub", file("sub.go", strings.Replace(string(sub), "sub", "sub;var koko int", 1)))),
+			file("a.go", `package test;var koko int;import "./locals/sub"`),
+		).AssertEqual("temp", t)
+		if _, ok := pkg.sourcemaps[must(filepath.Abs("temp/a.go"))]; !ok {
+			t.Error("No source map of cached file a.go")
+		}
+	}
+	instrument()
+	instrument()
+	if fmt.Sprint(patched) != "[sub.go a.go]" {
+		t.Fatal("Expected cached packages not to be patched again, patched", patched)
+	}
+	// a.go is patched again, as the package it imports changed
+	OrFail(ioutil.WriteFile("test/sub/sub.go", []byte("package  sub"), 0644), t)
+	instrument()
+	if fmt.Sprint(patched) != "[sub.go a.go sub.go a.go]" {
+		t.Fatal("Expected changed packages to be patched again, patched", patched)
+	}
+}
+
+func TestCacheOutput(t *testing.T) {
+	OrFail(dir("test", file("a.go", "package test")).Build("."), t)
+	defer func() { OrFail(os.RemoveAll("test"), t) }()
+	cachedir, err := ioutil.TempDir("", "cache")
+	OrFail(err, t)
+	defer func() { OrFail(os.RemoveAll(cachedir), t) }()
+	c := &cache{cachedir, "test", make(map[string]string)}
+	patched := 0
+	for n := 0; n < 2; n++ {
+		pkg, err := ImportDir(".", "test")
+		OrFail(err, t)
+		pkg.cache = c
+		in := newInstrumentation("temp", 1, func(pf *patch.PatchableFile) patch.Patches {
+			patched++
+			fmt.Fprintln(Output(pf), "a.go:1:1: undefined: koko")
+			return nil
+		})
+		OrFail(os.Mkdir("temp", 0755), t)
+		OrFail(pkg.instrumentTo(in, nil, false, ""), t)
+		OrFail(os.RemoveAll("temp"), t)
+		buf := new(bytes.Buffer)
+		in.flush(buf)
+		// a cached file is not patched again, but what patching it printed is
+		if patched != 1 || buf.String() != "a.go:1:1: undefined: koko\n" {
+			t.Fatal("Expected the output of the patched file, patched", patched, "times, got", buf.String())
+		}
+	}
+}
+
+func TestCacheUninstrumented(t *testing.T) {
+	OrFail(dir("gopath/src",
+		dir("mypkg", file("a.go", `package mypkg;import "dep"`)),
+		dir("dep", file("dep.go", "package dep")),
+	).Build("."), t)
+	defer func() { OrFail(os.RemoveAll("gopath"), t) }()
+	gopath, err := filepath.Abs("gopath")
+	OrFail(err, t)
+	prevgopath := build.Default.GOPATH
+	defer func() { build.Default.GOPATH = prevgopath }()
+	build.Default.GOPATH = gopath
+	cachedir, err := ioutil.TempDir("", "cache")
+	OrFail(err, t)
+	defer func() { OrFail(os.RemoveAll(cachedir), t) }()
+	c := &cache{cachedir, "test", make(map[string]string)}
+	patched := 0
+	instrument := func() {
+		pkg, err := Import("mypkg", "mypkg")
+		OrFail(err, t)
+		pkg.cache = c
+		OrFail(os.Mkdir("temp", 0755), t)
+		defer func() { OrFail(os.RemoveAll("temp"), t) }()
+		_, err = pkg.InstrumentTo(false, "temp", func(pf *patch.PatchableFile) patch.Patches {
+			patched++
+			return nil
+		})
+		OrFail(err, t)
+	}
+	instrument()
+	instrument()
+	if patched != 1 {
+		t.Fatal("Expected a cached package not to be patched again, patched", patched, "times")
+	}
+	// the patches may depend on the declarations of dep, which is not instrumented
+	OrFail(ioutil.WriteFile("gopath/src/dep/dep.go", []byte("package dep;func F() error"), 0644), t)
+	instrument()
+	if patched != 2 {
+		t.Fatal("Expected a package to be patched again when a package it imports changed, patched", patched, "times")
+	}
+}
+
+func TestCacheEvict(t *testing.T) {
+	cachedir, err := ioutil.TempDir("", "cache")
+	OrFail(err, t)
+	defer func() { OrFail(os.RemoveAll(cachedir), t) }()
+	c := &cache{cachedir, "test", make(map[string]string)}
+	old := time.Now().Add(-maxAge - time.Hour)
+	// the work directories are used one minute after the other, the first one too long ago
+	for i := 0; i < maxWorkdirs+2; i++ {
+		workdir := filepath.Join(cachedir, "work", fmt.Sprint(i))
+		OrFail(os.MkdirAll(workdir, 0755), t)
+		used := time.Now().Add(time.Duration(i-maxWorkdirs-2) * time.Minute)
+		if i == 0 {
+			used = old
+		}
+		OrFail(os.Chtimes(workdir, used, used), t)
+	}
+	OrFail(c.put("0000", nil), t)
+	OrFail(c.put("1111", nil), t)
+	OrFail(os.Chtimes(c.entry("0000"), old, old), t)
+	OrFail(c.evict(), t)
+	workdirs, err := ioutil.ReadDir(filepath.Join(cachedir, "work"))
+	OrFail(err, t)
+	if len(workdirs) != maxWorkdirs {
+		t.Fatal("Expected", maxWorkdirs, "work directories to be kept, kept", len(workdirs))
+	}
+	for _, evicted := range []string{"0", "1"} {
+		if _, err := os.Stat(filepath.Join(cachedir, "work", evicted)); !os.IsNotExist(err) {
+			t.Error("Expected work directory", evicted, "to be evicted")
+		}
+	}
+	if _, ok := c.get("0000"); ok {
+		t.Error("Expected an entry unused for", maxAge, "to be evicted")
+	}
+	if _, ok := c.get("1111"); !ok {
+		t.Error("Expected a recent entry to be kept")
+	}
+}
+
+func TestParallel(t *testing.T) {
+	OrFail(dir("test",
+		dir("a", file("a.go", `package a;import "../c"`)),
+		dir("b", file("b.go", `package b;import "../c"`)),
+		dir("c", file("c1.go", "package c"), file("c2.go", "package c")),
+		file("main.go", `package main;import ("./a";"./b")`),
+	).Build("."), t)
+	defer func() { OrFail(os.RemoveAll("test"), t) }()
+	for n := 0; n < 10; n++ {
+		pkg, err := ImportDir(".", "test")
+		OrFail(err, t)
+		in := newInstrumentation("temp", 4, func(pf *patch.PatchableFile) patch.Patches {
+			fmt.Fprintln(Output(pf), filepath.Base(pf.FileName))
+			return patch.Patches{patch.Replace(pf.File, "koko")}
+		})
+		OrFail(os.Mkdir("temp", 0755), t)
+		OrFail(pkg.instrumentTo(in, nil, false, ""), t)
+		dir("temp",
+			dir("locals",
+				dir("a", file("a.go", "koko")),
+				dir("b", file("b.go", "koko")),
+				dir("c", file("c1.go", "koko"), file("c2.go", "koko"))),
+			file("main.go", "koko"),
+		).AssertEqual("temp", t)
+		OrFail(os.RemoveAll("temp"), t)
+		buf := new(bytes.Buffer)
+		in.flush(buf)
+		if buf.String() != "a.go\nb.go\nc1.go\nc2.go\nmain.go\n" {
+			t.Fatal("Expected output by file name, got", buf.String())
+		}
+	}
+	// the error is of the first import that failed
+	OrFail(ioutil.WriteFile("test/a/a.go", []byte("package a;var"), 0644), t)
+	OrFail(ioutil.WriteFile("test/b/b.go", []byte("package b;var"), 0644), t)
+	for n := 0; n < 10; n++ {
+		pkg, err := ImportDir(".", "test")
+		OrFail(err, t)
+		_, err = pkg.InstrumentTo(false, "temp", func(pf *patch.PatchableFile) patch.Patches { return nil })
+		OrFail(os.RemoveAll("temp"), t)
+		if err == nil || !strings.Contains(err.Error(), "a.go") {
+			t.Fatal("Expected error of a.go, got", err)
+		}
+	}
+}
+
+func must(s string, err error) string {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
 func fatalCaller(t *testing.T, depth int, msgs ...interface{}) {
 	_, file, line, ok := runtime.Caller(depth + 1) // +1 to go up fatalCaller's stack
 	if !ok {
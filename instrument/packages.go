@@ -0,0 +1,254 @@
+package instrument
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/elazarl/gosloppy/patch"
+)
+
+// isPackageList returns whether params may name more than a single package, e.g. ./...
+func isPackageList(params []string) bool {
+	return len(params) > 1 || len(params) == 1 && strings.Contains(params[0], "...")
+}
+
+// importPackages returns the packages that patterns match, as go list lists them. The packages
+// must be in GOPATH, or in the module of the current directory.
+func importPackages(basedir, tags string, patterns []string) ([]*Instrumentable, error) {
+	args := []string{"list"}
+	if tags != "" {
+		args = append(args, "-tags="+tags)
+	}
+	list := exec.Command("go", append(args, patterns...)...)
+	list.Stderr = os.Stderr
+	out, err := list.Output()
+	if err != nil {
+		return nil, err
+	}
+	mod, err := findModule(".")
+	if err != nil {
+		return nil, err
+	}
+	pkgs := []*Instrumentable{}
+	for _, importpath := range strings.Fields(string(out)) {
+		var pkg *Instrumentable
+		switch {
+		case mod != nil:
+			pkg, err = mod.importPkg(basedir, importpath)
+		case strings.HasPrefix(importpath, "_"):
+			return nil, errors.New("Cannot instrument " + importpath + " with other packages, it is not in GOPATH")
+		default:
+			pkg, err = Import(basedir, importpath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	if len(pkgs) == 0 {
+		return nil, errors.New("No packages match " + strings.Join(patterns, " "))
+	}
+	if mod == nil && basedir == "" {
+		// the packages should import one another instrumented, so the base package guessed for
+		// each is widened to a path they all share
+		common := pkgs[0].basepkg
+		for _, pkg := range pkgs[1:] {
+			for common != "." && !hasPathPrefix(pkg.basepkg, common) {
+				common = path.Dir(common)
+			}
+		}
+		if common != "." {
+			for _, pkg := range pkgs {
+				pkg.basepkg = common
+			}
+		}
+	}
+	return pkgs, nil
+}
+
+// listPackages prints the packages that would be instrumented with pkgs, see Packages. Each is
+// printed once, after the packages it imports.
+func listPackages(pkgs []*Instrumentable, withtests bool) error {
+	listed := make(map[string]bool)
+	for _, pkg := range pkgs {
+		ids, err := pkg.Packages(withtests)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if !listed[id] {
+				listed[id] = true
+				fmt.Println(id)
+			}
+		}
+	}
+	return nil
+}
+
+// packagesCmd builds, tests, or vets pkgs, which are instrumented together, see
+// InstrumentPackages
+func packagesCmd(ctx context.Context, gocmd *GoCmd, pkgs []*Instrumentable, f func(*patch.PatchableFile) patch.Patches, w *watcher, x bool) error {
+	// go vet checks the tests as well
+	withtests := gocmd.Command == "test" || gocmd.Command == "vet"
+	outdir, hasGoroot, err := InstrumentPackages(pkgs, withtests, f)
+	if w != nil {
+		// the packages share their sources, see InstrumentPackagesTo
+		w.addSources(pkgs[0])
+	}
+	if gocmd.BuildFlags["work"] == "true" {
+		log.Println("Instrumenting to", outdir)
+	}
+	defer func() {
+		if gocmd.BuildFlags["work"] != "true" && pkgs[0].cache == nil {
+			if err := os.RemoveAll(outdir); err != nil {
+				log.Println("Cannot remove temporary dir", outdir, err)
+			}
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	workdir, err := filepath.Abs(gocmd.WorkDir)
+	if err != nil {
+		return err
+	}
+	names, err := instrumentedNames(pkgs, outdir)
+	if err != nil {
+		return err
+	}
+	stdout, err := newReportWriter(os.Stdout, outdir, gocmd.WorkDir, pkgs[0].sources, pkgs[0].sourcemaps)
+	if err != nil {
+		return err
+	}
+	reports, err := newReportWriter(os.Stderr, outdir, gocmd.WorkDir, pkgs[0].sources, pkgs[0].sourcemaps)
+	if err != nil {
+		return err
+	}
+	stdout.names, reports.names = names, names
+	flags := gocmd.BuildFlags.Clone()
+	if o := flags["o"]; o != "" && !filepath.IsAbs(o) {
+		// a trailing slash makes o a directory
+		flags["o"] = filepath.Join(workdir, o) + strings.TrimPrefix(o, strings.TrimRight(o, "/"))
+	}
+	params := []string{}
+	for _, pkg := range pkgs {
+		p, err := pkg.outputPath(rootPath(pkgs, pkg))
+		if err != nil {
+			return err
+		}
+		// as with go build, packages of tests alone are ignored
+		if withtests || len(pkg.Files()) > 0 {
+			params = append(params, "./"+filepath.ToSlash(p))
+		}
+	}
+	if gocmd.Command == "test" {
+		if err := linkOriginals(pkgs, outdir); err != nil {
+			return err
+		}
+		if flags["c"] == "true" && flags["o"] == "" {
+			// as go test -c does, each test binary is written to the current directory
+			flags["o"] = workdir + "/"
+		}
+	}
+	newgocmd := &GoCmd{make(map[string]string), outdir, "go", gocmd.Command, flags, params, gocmd.ExtraFlags}
+	if hasGoroot {
+		newgocmd.Env["GOROOT"] = filepath.Join(outdir, "goroot")
+	}
+	if x {
+		log.Println("In:", newgocmd.WorkDir)
+		log.Println("Executing:", newgocmd)
+	}
+	runnable := newgocmd.Runnable()
+	runnable.Stdout = stdout
+	runnable.Stderr = reports
+	err = runGroupContext(ctx, runnable)
+	stdout.Flush()
+	reports.Flush()
+	return err
+}
+
+// linkOriginals links the files of the directories of pkgs, but go files, and their testdata
+// directories, into the packages instrumented to outdir. Their tests run in the instrumented
+// directories, and find the files they read where they expect them. Other directories are not
+// linked, as a package may be instrumented into one later on.
+func linkOriginals(pkgs []*Instrumentable, outdir string) error {
+	for _, pkg := range pkgs {
+		if pkg.pkg.Dir == "" {
+			continue
+		}
+		p, err := pkg.outputPath(rootPath(pkgs, pkg))
+		if err != nil {
+			return err
+		}
+		infos, err := ioutil.ReadDir(pkg.pkg.Dir)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			name := info.Name()
+			if info.IsDir() && name != "testdata" || strings.HasSuffix(name, ".go") {
+				continue
+			}
+			link := filepath.Join(outdir, p, name)
+			if _, err := os.Lstat(link); err == nil {
+				// instrumented, copied, or linked before
+				continue
+			}
+			orig, err := filepath.Abs(filepath.Join(pkg.pkg.Dir, name))
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(orig, link); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// instrumentedNames returns the original import paths of the packages instrumented with pkgs
+// into outdir, by the import paths the go tool gives them. Packages outside a module are built by
+// directory, so the go tool names them _/outdir/path.
+func instrumentedNames(pkgs []*Instrumentable, outdir string) (map[string]string, error) {
+	names := make(map[string]string)
+	if pkgs[0].module != nil {
+		return names, nil
+	}
+	outdir, err := filepath.Abs(outdir)
+	if err != nil {
+		return nil, err
+	}
+	for instrumented, orig := range pkgs[0].sources {
+		dir := filepath.Dir(instrumented)
+		rel, err := filepath.Rel(outdir, dir)
+		if err != nil {
+			continue
+		}
+		// packages outside GOPATH are named by their directory as well
+		name := "_" + filepath.ToSlash(filepath.Dir(orig))
+		if rel = filepath.ToSlash(rel); strings.HasPrefix(rel, "gopath/") {
+			name = strings.TrimPrefix(rel, "gopath/")
+		}
+		names["_"+filepath.ToSlash(dir)] = name
+	}
+	for _, pkg := range pkgs {
+		if pkg.pkg.ImportPath == "" || build.IsLocalImport(pkg.pkg.ImportPath) {
+			continue
+		}
+		p, err := pkg.outputPath(rootPath(pkgs, pkg))
+		if err != nil {
+			return nil, err
+		}
+		names["_"+filepath.ToSlash(filepath.Join(outdir, p))] = pkg.pkg.ImportPath
+	}
+	return names, nil
+}
@@ -0,0 +1,155 @@
+package instrument
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/elazarl/gosloppy/patch"
+)
+
+// instrumentation is the state of a single InstrumentTo, shared by all the packages it
+// instruments concurrently
+type instrumentation struct {
+	outdir string
+	f      func(file *patch.PatchableFile) patch.Patches
+	// workers bounds the number of packages whose files are instrumented at once
+	workers chan struct{}
+	// mu guards processed, and the maps the instrumented packages share
+	mu sync.Mutex
+	// processed maps packages, by relative path and import path, to their instrumentation
+	processed map[string]*instrumented
+	// output holds what f printed to Output, by file name
+	output map[string]*bytes.Buffer
+	// written are the instrumented files, by path
+	written map[string]bool
+	// tests are the packages, by import path, instrumented with their tests wherever imported
+	tests map[string]bool
+	// xtests are the external test packages being instrumented, xtestErrs their errors by the
+	// relative path of the package they test
+	xtests    sync.WaitGroup
+	xtestErrs map[string]error
+}
+
+// instrumented is the instrumentation of a single package, done is closed when it is over
+type instrumented struct {
+	done chan struct{}
+	err  error
+}
+
+func newInstrumentation(outdir string, parallel int, f func(file *patch.PatchableFile) patch.Patches) *instrumentation {
+	if parallel < 1 {
+		parallel = runtime.NumCPU()
+	}
+	return &instrumentation{outdir: outdir, f: f, workers: make(chan struct{}, parallel),
+		processed: make(map[string]*instrumented), output: make(map[string]*bytes.Buffer),
+		written: make(map[string]bool), tests: make(map[string]bool), xtestErrs: make(map[string]error)}
+}
+
+// xtestErr returns the error of the first external test package that failed, by path
+func (in *instrumentation) xtestErr() error {
+	paths := []string{}
+	for path := range in.xtestErrs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := in.xtestErrs[path]; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// start returns the instrumentation of the package with the given relative path, and whether it
+// was already started. Otherwise, the caller should instrument the package and close done.
+func (in *instrumentation) start(relpath, importpath string) (pkg *instrumented, started bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if pkg, ok := in.processed[relpath]; ok {
+		return pkg, true
+	}
+	pkg = &instrumented{done: make(chan struct{})}
+	in.processed[relpath] = pkg
+	if _, ok := in.processed[importpath]; !ok {
+		in.processed[importpath] = pkg
+	}
+	return pkg, false
+}
+
+var (
+	outputsMu sync.Mutex
+	outputs   = make(map[*patch.PatchableFile]io.Writer)
+)
+
+// Output returns the writer the function patching file should print messages about it to, such as
+// warnings. Packages are instrumented concurrently, so InstrumentTo buffers the messages, and
+// prints them once it is done, by order of file names. Otherwise, Output returns os.Stderr.
+func Output(file *patch.PatchableFile) io.Writer {
+	outputsMu.Lock()
+	defer outputsMu.Unlock()
+	if w, ok := outputs[file]; ok {
+		return w
+	}
+	return os.Stderr
+}
+
+// patch calls f with file, buffering what it prints to Output
+func (in *instrumentation) patch(file *patch.PatchableFile) patch.Patches {
+	buf := new(bytes.Buffer)
+	outputsMu.Lock()
+	outputs[file] = buf
+	outputsMu.Unlock()
+	defer func() {
+		outputsMu.Lock()
+		delete(outputs, file)
+		outputsMu.Unlock()
+		in.mu.Lock()
+		in.output[file.FileName] = buf
+		in.mu.Unlock()
+	}()
+	return in.f(file)
+}
+
+// flush prints what f printed to Output, by order of file names
+func (in *instrumentation) flush(w io.Writer) {
+	names := []string{}
+	for name := range in.output {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.Write(in.output[name].Bytes())
+	}
+}
+
+// writeFile writes an instrumented file, unless it is already there with the same content
+func (in *instrumentation) writeFile(name string, content []byte) error {
+	in.mu.Lock()
+	in.written[name] = true
+	in.mu.Unlock()
+	if old, err := ioutil.ReadFile(name); err == nil && bytes.Equal(old, content) {
+		return nil
+	}
+	return ioutil.WriteFile(name, content, 0644)
+}
+
+// removeStale removes the go files of outdir which were not written, as they were instrumented
+// into it before from files that no longer exist, or are no longer built
+func (in *instrumentation) removeStale() error {
+	return filepath.Walk(in.outdir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && strings.HasSuffix(path, ".go") && !in.written[path] {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package instrument
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// processGroup makes cmd start a process group of its own, and returns a function killing it,
+// along with the processes it started
+func processGroup(cmd *exec.Cmd) (kill func() error) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+}
@@ -0,0 +1,275 @@
+package instrument
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/elazarl/gosloppy/patch"
+)
+
+// replPreamble starts the script a session runs. Names starting with __ are reserved for it.
+// The output of the statements that already ran is discarded, as they run again.
+const replPreamble = `import __fmt "fmt"
+import __os "os"
+func __print(vs ...interface{}) {
+	for _, v := range vs {
+		__fmt.Printf("%#v\n", v)
+	}
+}
+__stdout := __os.Stdout
+__os.Stdout, _ = __os.OpenFile(__os.DevNull, __os.O_WRONLY, 0)
+`
+
+// session is the state of a REPL
+type session struct {
+	f   func(*patch.PatchableFile) patch.Patches
+	out io.Writer
+	// entries are the statements and declarations that ran so far
+	entries []string
+	// imports are the import specs of the last program that ran, auto imported ones included
+	imports []string
+}
+
+// repl reads statements from in, one at a time, and after each one runs it, and all the
+// statements before it, as a script, see wrapScript. If the statement is an expression, its
+// value is printed. Statements that do not compile, or fail, are forgotten. The commands
+// ":imports" prints the imports the statements need, ":reset" forgets all statements, and
+// ":save file.go" writes them as a main package.
+func repl(f func(*patch.PatchableFile) patch.Patches, in io.Reader, out io.Writer) error {
+	s := &session{f: f, out: out}
+	lines := bufio.NewScanner(in)
+	input := ""
+	for {
+		if input == "" {
+			fmt.Fprint(out, "gosloppy> ")
+		} else {
+			fmt.Fprint(out, "... ")
+		}
+		if !lines.Scan() {
+			fmt.Fprintln(out)
+			return lines.Err()
+		}
+		input += lines.Text() + "\n"
+		if !complete(input) {
+			continue
+		}
+		switch fields := strings.Fields(input); {
+		case len(fields) == 0:
+		case fields[0] == ":imports" && len(fields) == 1:
+			for _, imp := range s.imports {
+				fmt.Fprintln(out, imp)
+			}
+		case fields[0] == ":reset" && len(fields) == 1:
+			s.entries, s.imports = nil, nil
+		case fields[0] == ":save" && len(fields) == 2:
+			if err := s.save(fields[1]); err != nil {
+				fmt.Fprintln(out, err)
+			}
+		case strings.HasPrefix(fields[0], ":"):
+			fmt.Fprintln(out, "commands: :imports, :reset, :save file.go")
+		default:
+			s.eval(input)
+		}
+		input = ""
+	}
+}
+
+// complete returns whether input has no unclosed parentheses, brackets or braces
+func complete(input string) bool {
+	fset := token.NewFileSet()
+	var s scanner.Scanner
+	s.Init(fset.AddFile("", -1, len(input)), []byte(input), nil, 0)
+	depth := 0
+	for {
+		_, tok, _ := s.Scan()
+		switch tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		case token.EOF:
+			return depth <= 0
+		}
+	}
+}
+
+// eval runs input after the session's entries, and adds it to them if it runs. An expression's
+// value is printed instead, a call that has no value runs as a statement.
+func (s *session) eval(input string) {
+	if expr, err := parser.ParseExpr(input); err == nil {
+		_, call := expr.(*ast.CallExpr)
+		imports, errs, err := s.run(input, true)
+		if errs == "" || !call {
+			fmt.Fprint(s.out, errs)
+			if errs == "" && err == nil {
+				s.imports = imports
+				// it may have side effects the statements following it depend on, unless it is
+				// a conversion, or a call of a builtin like len, which are no statements
+				if call && s.compiles(input) {
+					s.entries = append(s.entries, input)
+				}
+			}
+			return
+		}
+	}
+	imports, errs, err := s.run(input, false)
+	fmt.Fprint(s.out, errs)
+	if errs == "" && err == nil {
+		s.imports = imports
+		s.entries = append(s.entries, input)
+	}
+}
+
+// replErrorRegexp matches the position of a compile error in the script a session runs
+var replErrorRegexp = regexp.MustCompile(`(?m)^\S*\.gos:(\d+):(\d+): `)
+
+// run instruments the script of the session's entries followed by input, builds it, and runs it
+// if it compiles, printing input's value if print is set. It returns the imports of the
+// instrumented program, or why it could not run it, e.g. compile errors positioned in input.
+// A program that fails prints its own errors.
+func (s *session) run(input string, print bool) (imports []string, errs string, err error) {
+	dir, err := ioutil.TempDir("", "__gosloppy_repl")
+	if err != nil {
+		return nil, err.Error() + "\n", err
+	}
+	defer os.RemoveAll(dir)
+	bin, imports, errs, err := s.build(dir, input, print)
+	if err != nil {
+		return nil, errs, err
+	}
+	program := exec.Command(bin)
+	program.Stdout = s.out
+	program.Stderr = s.out
+	if err := program.Run(); err != nil {
+		fmt.Fprintln(s.out, err)
+		return nil, "", err
+	}
+	return imports, "", nil
+}
+
+// compiles returns whether input compiles as a statement following the session's entries
+func (s *session) compiles(input string) bool {
+	dir, err := ioutil.TempDir("", "__gosloppy_repl")
+	if err != nil {
+		return false
+	}
+	defer os.RemoveAll(dir)
+	_, _, _, err = s.build(dir, input, false)
+	return err == nil
+}
+
+// build writes the script run runs into dir, and builds it there, see run. It returns the program
+// it built, and its imports.
+func (s *session) build(dir, input string, print bool) (bin string, imports []string, errs string, err error) {
+	script := replPreamble + strings.Join(s.entries, "") + "__os.Stdout = __stdout\n"
+	inputline := strings.Count(script, "\n") + 1
+	if print {
+		script += "__print(\n" + strings.TrimRight(input, "\n") + ",\n)\n"
+		inputline++
+	} else {
+		script += input
+	}
+	wrapped, err := wrapScript(filepath.Join(dir, "repl.gos"), []byte(script))
+	if err != nil {
+		return "", nil, replErrors(err.Error()+"\n", inputline), err
+	}
+	gofile := filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(gofile, wrapped, 0644); err != nil {
+		return "", nil, err.Error() + "\n", err
+	}
+	outdir, _, err := ImportFiles("", gofile).Instrument(false, s.f)
+	defer os.RemoveAll(outdir)
+	if err != nil {
+		// syntax errors are found while instrumenting
+		return "", nil, replErrors(err.Error()+"\n", inputline), err
+	}
+	bin = filepath.Join(dir, "repl")
+	build := exec.Command("go", "build", "-o", bin, "main.go")
+	build.Dir = outdir
+	stderr := new(bytes.Buffer)
+	build.Stderr = stderr
+	if err := build.Run(); err != nil {
+		return "", nil, replErrors(stderr.String(), inputline), err
+	}
+	if imports, err = importSpecs(filepath.Join(outdir, "main.go")); err != nil {
+		return "", nil, err.Error() + "\n", err
+	}
+	return bin, imports, "", nil
+}
+
+// replErrors returns the errors of the go tool, with positions in the input starting at line
+// inputline of the script
+func replErrors(errs string, inputline int) string {
+	lines := []string{}
+	for _, line := range strings.SplitAfter(errs, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, replErrorRegexp.ReplaceAllStringFunc(line, func(pos string) string {
+			submatches := replErrorRegexp.FindStringSubmatch(pos)
+			l, _ := strconv.Atoi(submatches[1])
+			if l < inputline {
+				return ""
+			}
+			return strconv.Itoa(l-inputline+1) + ":" + submatches[2] + ": "
+		}))
+	}
+	return strings.Join(lines, "")
+}
+
+// importSpecs returns the import specs of gofile, but those of the REPL itself
+func importSpecs(gofile string) ([]string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), gofile, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	specs := []string{}
+	for _, imp := range file.Imports {
+		switch {
+		case imp.Name == nil:
+			specs = append(specs, imp.Path.Value)
+		case !strings.HasPrefix(imp.Name.Name, "__"):
+			specs = append(specs, imp.Name.Name+" "+imp.Path.Value)
+		}
+	}
+	return specs, nil
+}
+
+// save writes the session's entries to filename, as a main package importing what they need
+func (s *session) save(filename string) error {
+	parts, err := parseScript(filename, []byte(strings.Join(s.entries, "")))
+	if err != nil {
+		return err
+	}
+	decls, body := new(bytes.Buffer), new(bytes.Buffer)
+	for _, part := range parts {
+		switch {
+		case strings.HasPrefix(part.text, "import"):
+			// the imports of the last program include it
+		case part.decl:
+			fmt.Fprintf(decls, "\n%s\n", part.text)
+		default:
+			fmt.Fprintf(body, "%s\n", part.text)
+		}
+	}
+	src := []byte("package main\n\nimport (\n" + strings.Join(s.imports, "\n") + "\n)\n" + decls.String() +
+		"\nfunc main() {\n" + body.String() + "}\n")
+	if formatted, err := format.Source(src); err == nil {
+		src = formatted
+	}
+	return ioutil.WriteFile(filename, src, 0644)
+}
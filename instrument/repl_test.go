@@ -0,0 +1,61 @@
+package instrument
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/elazarl/gosloppy/patch"
+	"github.com/elazarl/gosloppy/scopes"
+	"github.com/elazarl/gosloppy/visitors"
+)
+
+func sloppify(p *patch.PatchableFile) patch.Patches {
+	patches := &visitors.PatchUnused{Patches: patch.Patches{}}
+	autoimport := visitors.NewLocalAutoImporter(p)
+	scopes.WalkFile(visitors.NewMultiVisitor(visitors.NewUnused(patches), autoimport), p.File)
+	return append(patches.Patches, autoimport.Patches...)
+}
+
+func TestRepl(t *testing.T) {
+	saved, err := ioutil.TempFile("", "saved")
+	OrFail(err, t)
+	saved.Close()
+	defer func() { OrFail(os.Remove(saved.Name()), t) }()
+	in := strings.Join([]string{
+		"x := 40",
+		"x + 2",
+		// a call of a builtin is no statement, it is not run again
+		"len(fmt.Sprint(x))",
+		`fmt.Println("once")`,
+		"func double(n int) int {",
+		"	return n * 2",
+		"}",
+		"double(x)",
+		"y := undefined",
+		":imports",
+		":save " + saved.Name(),
+		":reset",
+		"x",
+	}, "\n")
+	out := new(bytes.Buffer)
+	OrFail(repl(sloppify, strings.NewReader(in), out), t)
+	outputs := strings.Split(out.String(), "gosloppy> ")
+	expected := []string{"", "", "42\n", "2\n", "once\n", "... ... ", "80\n", "1:6: undefined: undefined\n", `"fmt"` + "\n", "", "",
+		"1:1: undefined: x\n", "\n"}
+	if len(outputs) != len(expected) {
+		t.Fatalf("Expected %d prompts, got %q", len(expected), out.String())
+	}
+	for i := range expected {
+		// the values fmt.Println returns are printed as well
+		if !strings.HasPrefix(outputs[i], expected[i]) {
+			t.Errorf("Expected output %d to be %q, got %q", i, expected[i], outputs[i])
+		}
+	}
+	b, err := ioutil.ReadFile(saved.Name())
+	OrFail(err, t)
+	expectEq("package main\n\nimport (\n\t\"fmt\"\n)\n\nfunc double(n int) int {\n\treturn n * 2\n}\n\n"+
+		"func main() {\n\tx := 40\n\tfmt.Println(\"once\")\n\tdouble(x)\n}\n", string(b), t)
+}
@@ -0,0 +1,132 @@
+package instrument
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/elazarl/gosloppy/patch"
+)
+
+// reportWriter rewrites file names in the go tool's reports (e.g. compile errors or `go vet`
+// warnings), from files in the instrumented directory back to the original files they were
+// generated from. Lines and columns are mapped back as well, with the source maps of the
+// instrumented files.
+type reportWriter struct {
+	w io.Writer
+	// dir is the directory the go tool runs in, relative paths in reports are relative to it
+	dir string
+	// wd is the directory rewritten paths will be relative to
+	wd string
+	// sources maps absolute paths of instrumented files to their original files
+	sources map[string]string
+	// sourcemaps maps absolute paths of instrumented files to their source maps
+	sourcemaps map[string]*patch.SourceMap
+	// originals holds the original files, which line directives in instrumented files refer to
+	originals map[string]bool
+	// names maps the import paths the go tool gives instrumented packages, e.g. _/tmp/dir, to the
+	// original ones
+	names map[string]string
+	buf   []byte
+}
+
+func newReportWriter(w io.Writer, dir, wd string, sources map[string]string, sourcemaps map[string]*patch.SourceMap) (*reportWriter, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	wd, err = filepath.Abs(wd)
+	if err != nil {
+		return nil, err
+	}
+	originals := make(map[string]bool)
+	for _, orig := range sources {
+		originals[orig] = true
+	}
+	return &reportWriter{w, dir, wd, sources, sourcemaps, originals, nil, nil}, nil
+}
+
+// localImportPathRegexp matches the import path the go tool gives a package built by directory.
+// A backslash ends it as well, since in `go test -json` output a tab is escaped as \t
+var localImportPathRegexp = regexp.MustCompile(`_/[^\s"\[\]\\]+`)
+
+// goFileRegexp matches a go file or a script, and optionally a line and a column, e.g. a.go:1:2
+var goFileRegexp = regexp.MustCompile(`([^\s:]+\.gos?)(?::(\d+)(?::(\d+))?)?`)
+
+// Write buffers b, and writes every complete line with rewritten paths to the underlying writer
+func (r *reportWriter) Write(b []byte) (int, error) {
+	r.buf = append(r.buf, b...)
+	for {
+		nl := bytes.IndexByte(r.buf, '\n')
+		if nl < 0 {
+			return len(b), nil
+		}
+		if _, err := r.w.Write(r.rewrite(r.buf[:nl+1])); err != nil {
+			return len(b), err
+		}
+		r.buf = r.buf[nl+1:]
+	}
+}
+
+// Flush writes a pending incomplete line, if any
+func (r *reportWriter) Flush() error {
+	if len(r.buf) == 0 {
+		return nil
+	}
+	_, err := r.w.Write(r.rewrite(r.buf))
+	r.buf = nil
+	return err
+}
+
+func (r *reportWriter) rewrite(line []byte) []byte {
+	line = localImportPathRegexp.ReplaceAllFunc(line, func(match []byte) []byte {
+		// test binaries, and external test packages, are named after their package
+		path := strings.TrimSuffix(strings.TrimSuffix(string(match), ".test"), "_test")
+		if orig, ok := r.names[path]; ok {
+			return []byte(orig + string(match[len(path):]))
+		}
+		return match
+	})
+	return goFileRegexp.ReplaceAllFunc(line, func(match []byte) []byte {
+		submatches := goFileRegexp.FindSubmatch(match)
+		path := string(submatches[1])
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(r.dir, path)
+		}
+		path = filepath.Clean(path)
+		orig, ok := r.sources[path]
+		if !ok && r.originals[path] {
+			// positions following a line directive are already those of the original file
+			orig, ok = path, true
+		}
+		if !ok {
+			return match
+		}
+		m := r.sourcemaps[path]
+		if m == nil || submatches[2] == nil {
+			return []byte(r.relative(orig) + string(match[len(submatches[1]):]))
+		}
+		line, _ := strconv.Atoi(string(submatches[2]))
+		if submatches[3] == nil {
+			return []byte(r.relative(orig) + ":" + strconv.Itoa(m.OriginalPosition(m.Offset(line, 1)).Line))
+		}
+		col, _ := strconv.Atoi(string(submatches[3]))
+		pos := m.OriginalPosition(m.Offset(line, col))
+		return []byte(r.relative(orig) + ":" + strconv.Itoa(pos.Line) + ":" + strconv.Itoa(pos.Column))
+	})
+}
+
+// relative returns the path of the original file orig relative to wd
+func (r *reportWriter) relative(orig string) string {
+	rel, err := filepath.Rel(r.wd, orig)
+	if err != nil {
+		return orig
+	}
+	if !filepath.IsAbs(rel) && rel[0] != '.' {
+		rel = "." + string(filepath.Separator) + rel
+	}
+	return rel
+}
@@ -0,0 +1,76 @@
+package instrument
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"testing"
+
+	"github.com/elazarl/gosloppy/patch"
+)
+
+func TestReportWriter(t *testing.T) {
+	sources := map[string]string{
+		filepath.FromSlash("/tmp/__instrument.go1/a.go"):                 filepath.FromSlash("/home/u/pkg/a.go"),
+		filepath.FromSlash("/tmp/__instrument.go1/locals/__/sub/sub.go"): filepath.FromSlash("/home/u/sub/sub.go"),
+	}
+	buf := new(bytes.Buffer)
+	w, err := newReportWriter(buf, "/tmp/__instrument.go1", "/home/u/pkg", sources, nil)
+	OrFail(err, t)
+	w.Write([]byte("# _/tmp/__instrument.go1\n./a.go:3:2: unreachable code\nlocals/__/sub/sub"))
+	w.Write([]byte(".go:1:1: bad\nb.go:1:1: untouched"))
+	OrFail(w.Flush(), t)
+	expectEq("# _/tmp/__instrument.go1\n./a.go:3:2: unreachable code\n../sub/sub.go:1:1: bad\nb.go:1:1: untouched",
+		buf.String(), t)
+}
+
+func TestReportWriterNames(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := newReportWriter(buf, "/tmp/__instrument.go1", "/home/u/pkg", nil, nil)
+	OrFail(err, t)
+	w.names = map[string]string{"_/tmp/__instrument.go1": "a/pkg", "_/tmp/__instrument.go1/gopath/a/sub": "a/sub"}
+	w.Write([]byte("# _/tmp/__instrument.go1_test [_/tmp/__instrument.go1.test]\n"))
+	w.Write([]byte("ok  \t_/tmp/__instrument.go1/gopath/a/sub\t0.01s\nok  \t_/tmp/other\t0.01s\n"))
+	expectEq("# a/pkg_test [a/pkg.test]\nok  \ta/sub\t0.01s\nok  \t_/tmp/other\t0.01s\n", buf.String(), t)
+}
+
+func TestReportWriterNamesJSON(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := newReportWriter(buf, "/tmp/__instrument.go1", "/home/u/pkg", nil, nil)
+	OrFail(err, t)
+	w.names = map[string]string{"_/tmp/__instrument.go1": "a/pkg"}
+	w.Write([]byte(`{"Action":"output","Package":"_/tmp/__instrument.go1","Output":"ok  \t_/tmp/__instrument.go1\t0.01s\n"}` + "\n"))
+	expectEq(`{"Action":"output","Package":"a/pkg","Output":"ok  \ta/pkg\t0.01s\n"}`+"\n", buf.String(), t)
+}
+
+func TestReportWriterColumns(t *testing.T) {
+	code := "package main\n\nfunc f() {\n\ta := 1; b := undefined\n\tc := 1\n\td := undefined\n}\n"
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "/home/u/pkg/a.go", code, 0)
+	OrFail(err, t)
+	patchable := &patch.PatchableFile{PkgName: "main", FileName: "/home/u/pkg/a.go", File: file, Fset: fset, Orig: code, LineDirectives: true}
+	body := file.Decls[0].(*ast.FuncDecl).Body.List
+	a, b, c := body[0].(*ast.AssignStmt), body[1], body[2]
+	// the instrumented file is:
+	// func f() {
+	//	a := 1;_ = a; b := undefined;_ = b
+	//	c := 1
+	//	/*line /home/u/pkg/a.go:6:2*/d := undefined
+	// }
+	patches := patch.Patches{patch.Insert(a.End(), ";_ = a"), patch.Insert(b.End(), ";_ = b"),
+		patch.Insert(c.End(), "\n")}
+	sourcemap, err := patchable.FprintPatchedSourceMap(new(bytes.Buffer), patchable.All(), patches)
+	OrFail(err, t)
+	sources := map[string]string{filepath.FromSlash("/tmp/__instrument.go1/a.go"): filepath.FromSlash("/home/u/pkg/a.go")}
+	sourcemaps := map[string]*patch.SourceMap{filepath.FromSlash("/tmp/__instrument.go1/a.go"): sourcemap}
+	buf := new(bytes.Buffer)
+	w, err := newReportWriter(buf, "/tmp/__instrument.go1", "/home/u/pkg", sources, sourcemaps)
+	OrFail(err, t)
+	w.Write([]byte("./a.go:4:22: undefined: undefined\n./a.go:4:11: in patch\n./a.go:4:2: before\n"))
+	w.Write([]byte("/home/u/pkg/a.go:6:7: undefined: undefined\n./a.go:5: no column\n"))
+	OrFail(w.Flush(), t)
+	expectEq("./a.go:4:16: undefined: undefined\n./a.go:4:8: in patch\n./a.go:4:2: before\n"+
+		"./a.go:6:7: undefined: undefined\n./a.go:5: no column\n", buf.String(), t)
+}
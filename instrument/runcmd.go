@@ -1,11 +1,16 @@
 package instrument
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"go/build"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/elazarl/gosloppy/patch"
@@ -23,30 +28,167 @@ import (
 //         "goCommandNameIsIgnored", "test")
 //     // You can even instrument pacakges in $GOROOT if you use the -goroot switch
 //     InstrumentCmd(f, "go", "test", "-goroot", "net/url")
+//
+// Five commands are not passed to the go tool. "inline" instruments the files in place,
+// "sloppify" prints the instrumented files (or with -diff, a unified diff against the originals)
+//     InstrumentCmd(f, "go", "sloppify", "-diff", "./foo")
+// "script" runs a script, a go file with no package clause, with the rest of the arguments
+//     InstrumentCmd(f, "go", "script", "deploy.gos", "production")
+// "repl" reads statements from stdin and runs them, see repl,
+// and "clean -cache" removes the cache of instrumented packages, see UseCache. Use -nocache to
+// instrument all packages anew.
+// Packages are instrumented concurrently, as many as the -p flag allows, see InstrumentTo.
+// test, build and vet take several packages, or patterns such as ./..., as well, which are
+// instrumented together, see InstrumentPackagesTo, and list lists the packages each of them
+// would instrument. go test runs in the instrumented directory,
+// with every flag it was given.
 func InstrumentCmd(f func(*patch.PatchableFile) patch.Patches, args ...string) (err error) {
+	return InstrumentCmdWithFlags(flag.NewFlagSet("", flag.ContinueOnError), f, args...)
+}
+
+// InstrumentCmdWithFlags is like InstrumentCmd, but will parse flags configured in fl as well.
+// Those flags are not passed to the go tool.
+func InstrumentCmdWithFlags(fl *flag.FlagSet, f func(*patch.PatchableFile) patch.Patches, args ...string) (err error) {
+	if len(args) > 1 && args[1] == "watch" {
+		return watch(fl, f, append([]string{args[0]}, args[2:]...)...)
+	}
+	return instrumentCmd(context.Background(), fl, f, nil, args...)
+}
+
+// instrumentCmd is InstrumentCmdWithFlags, killing the commands it runs if ctx is done. The
+// files the instrumented packages are made of are added to w, unless it is nil.
+func instrumentCmd(ctx context.Context, fl *flag.FlagSet, f func(*patch.PatchableFile) patch.Patches, w *watcher, args ...string) (err error) {
 	var pkg *Instrumentable
 	if len(args) > 1 && args[1] == "inline" {
-		switch args := args[2:]; {
-		case len(args) == 0:
-			pkg, err = ImportDir("", ".")
-		case len(args) > 1 || strings.HasSuffix(args[0], ".go"):
-			pkg = ImportFiles("", args...)
-		default:
-			pkg, err = Import("", args[0])
+		if pkg, err = importArgs(args[2:]); err != nil {
+			return err
 		}
 		return pkg.InstrumentInline(f)
 	}
+	if len(args) > 1 && args[1] == "sloppify" {
+		fl := flag.NewFlagSet(args[1], flag.ContinueOnError)
+		diff := fl.Bool("diff", false, "print a unified diff against the original files")
+		withtests := fl.Bool("test", false, "print test files as well")
+		if err := fl.Parse(args[2:]); err != nil {
+			return err
+		}
+		if pkg, err = importArgs(fl.Args()); err != nil {
+			return err
+		}
+		return pkg.InstrumentPrint(os.Stdout, *withtests, *diff, f)
+	}
+	if len(args) > 1 && args[1] == "clean" {
+		fl := flag.NewFlagSet(args[1], flag.ContinueOnError)
+		cleancache := fl.Bool("cache", false, "remove the cache of instrumented packages")
+		if err := fl.Parse(args[2:]); err != nil {
+			return err
+		}
+		if !*cleancache || fl.NArg() > 0 {
+			return errors.New("usage: clean -cache")
+		}
+		return CleanCache()
+	}
+	if len(args) > 1 && args[1] == "repl" {
+		return repl(f, os.Stdin, os.Stdout)
+	}
+	// a script is wrapped into a main package, which we build, then run with the script's args
+	var script string
+	var scriptargs []string
+	if len(args) > 1 && args[1] == "script" {
+		if len(args) < 3 {
+			return errors.New("usage: script file.gos [args]")
+		}
+		gofile, err := wrapScriptFile(args[2])
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(filepath.Dir(gofile))
+		script, scriptargs = args[2], args[3:]
+		args = []string{args[0], "run", gofile}
+	}
 
-	fl := flag.NewFlagSet("", flag.ContinueOnError)
 	basedir := fl.String("basedir", "", "instrument all packages decendant f basedir")
 	goroot := fl.Bool("goroot", false, "Should I instrument packages in $GOROOT/src/pkg? (can take time)")
+	nocache := fl.Bool("nocache", false, "instrument all packages, even if they did not change since the last time")
+	ownflags := []string{}
+	fl.VisitAll(func(f *flag.Flag) { ownflags = append(ownflags, f.Name) })
 	gocmd, err := NewGoCmdWithFlags(fl, ".", args...)
 	if err != nil {
 		return err
 	}
+	// flags of the instrumentation must not be passed to the go tool
+	for _, name := range ownflags {
+		delete(gocmd.BuildFlags, name)
+	}
+	if script != "" {
+		gocmd.ExtraFlags = scriptargs
+	}
+	if gocmd.Command == "generate" {
+		// go generate does not compile the package, and must write its output next to the
+		// original sources, so it runs as is.
+		gocmd.Executable = "go"
+		return gocmd.Runnable().Run()
+	}
 
-	if gocmd.Command == "run" {
+	// the values of our flags may change the instrumentation, and the wrapped script is in a new
+	// directory every time
+	var c *cache
+	if UseCache && !*nocache && script == "" {
+		params := []string{}
+		for _, name := range ownflags {
+			params = append(params, name+"="+fl.Lookup(name).Value.String())
+		}
+		var cerr error
+		if c, cerr = newCache(params...); cerr != nil {
+			log.Println("Cannot use the cache of instrumented packages:", cerr)
+			c = nil
+		}
+		if c != nil {
+			if err := c.evict(); err != nil {
+				log.Println("Cannot evict old instrumented packages from the cache:", err)
+			}
+		}
+	}
+	configure := func(pkg *Instrumentable) {
+		pkg.InstrumentGoroot = *goroot
+		if p, err := strconv.Atoi(fl.Lookup("p").Value.String()); err == nil {
+			pkg.Parallel = p
+		}
+		pkg.cache = c
+	}
+	// go run takes several files, rather than packages
+	if gocmd.Command != "run" && isPackageList(gocmd.Params) {
+		pkgs, err := importPackages(*basedir, gocmd.BuildFlags["tags"], gocmd.Params)
+		if err != nil {
+			return err
+		}
+		for _, pkg := range pkgs {
+			configure(pkg)
+		}
+		if gocmd.Command == "list" {
+			return listPackages(pkgs, gocmd.BuildFlags["test"] == "true")
+		}
+		return packagesCmd(ctx, gocmd, pkgs, f, w, fl.Lookup("x").Value.String() == "true")
+	}
+	if gocmd.Command == "run" && len(gocmd.Params) == 0 {
+		return errors.New("usage: run [build flags] package|files [arguments]")
+	}
+	// go run runs either a list of files, or a package like go build builds
+	runfiles := gocmd.Command == "run" && strings.HasSuffix(gocmd.Params[0], ".go")
+	if runfiles {
 		pkg = ImportFiles(*basedir, gocmd.Params...)
+	} else if mod, err := findModule("."); err != nil {
+		return err
+	} else if mod != nil {
+		pkgname := "."
+		if len(gocmd.Params) > 0 {
+			pkgname = gocmd.Params[0]
+		}
+		if pkg, err = mod.importPkg(*basedir, pkgname); err != nil {
+			return err
+		}
+		// the output name is derived from the import path, as the go tool does
+		gocmd.Params = []string{pkg.pkg.ImportPath}
 	} else if len(gocmd.Params) == 0 {
 		wd, err := os.Getwd()
 		if err != nil {
@@ -74,19 +216,36 @@ func InstrumentCmd(f func(*patch.PatchableFile) patch.Patches, args ...string) (
 		if len(gocmd.Params) == 0 {
 			pkg, err = ImportDir(*basedir, ".")
 		}
+	} else if build.IsLocalImport(gocmd.Params[0]) {
+		if pkg, err = ImportDir(*basedir, gocmd.Params[0]); err != nil {
+			return err
+		}
 	}
 	if pkg == nil {
 		if pkg, err = Import(*basedir, gocmd.Params[0]); err != nil {
 			return err
 		}
 	}
-	pkg.InstrumentGoroot = *goroot
-	outdir, hasGoroot, err := pkg.Instrument(gocmd.Command == "test", f)
+	configure(pkg)
+	if gocmd.Command == "list" {
+		return listPackages([]*Instrumentable{pkg}, gocmd.BuildFlags["test"] == "true")
+	}
+	outdir, hasGoroot, err := pkg.Instrument(gocmd.Command == "test" || gocmd.Command == "vet", f)
+	if w != nil {
+		w.addSources(pkg)
+	}
+	if script != "" {
+		// positions in the wrapped script are those of the script, see wrapScript
+		if abs, err := filepath.Abs(script); err == nil {
+			pkg.sources[abs] = abs
+		}
+	}
 	if gocmd.BuildFlags["work"] == "true" {
 		log.Println("Instrumenting to", outdir)
 	}
 	defer func() {
-		if gocmd.BuildFlags["work"] != "true" {
+		// a cached package is instrumented to the same directory next time
+		if gocmd.BuildFlags["work"] != "true" && pkg.cache == nil {
 			if err := os.RemoveAll(outdir); err != nil {
 				log.Println("Cannot remove temporary dir", outdir, err)
 			}
@@ -104,64 +263,113 @@ func InstrumentCmd(f func(*patch.PatchableFile) patch.Patches, args ...string) (
 	}
 	newgocmd.Executable = "go"
 	// TODO(elazarl): Support build gofile.go gofile2.go
-	if newgocmd.Command != "run" && !pkg.pkg.Goroot { // goroot package must be in its place
+	// goroot package must be in its place, module packages are built by import path from the module root
+	if !runfiles && !pkg.pkg.Goroot && pkg.module == nil {
 		newgocmd.Params = nil
 	}
-	// TODO(elazarl): hackish, find better way
-	delete(newgocmd.BuildFlags, "basedir")
-	delete(newgocmd.BuildFlags, "goroot")
-	minusC := newgocmd.BuildFlags["c"] != ""
+	// go test runs the tests in the instrumented package, next to links to the original files,
+	// see linkOriginals. It caches the results of packages only when given by name.
 	if newgocmd.Command == "test" {
-		newgocmd.BuildFlags["c"] = "true"
-	}
-	if fl.Lookup("x").Value.String() == "true" {
-		log.Println("In:", newgocmd.WorkDir)
-		log.Println("Executing:", newgocmd)
-	}
-	if err := newgocmd.Runnable().Run(); err != nil {
-		return err
-	}
-	if newgocmd.Command == "test" {
-		finalname, _, err := gocmd.OutputFileName()
-		workdir, err := filepath.Abs(gocmd.WorkDir)
-		if err != nil {
+		if err := linkOriginals([]*Instrumentable{pkg}, outdir); err != nil {
 			return err
 		}
-		finalname = filepath.Join(workdir, finalname)
-		if err != nil {
-			panic("Should never happen: Cannot find package name, not producing test executable")
-		}
-		currname, _, err := newgocmd.OutputFileName()
-		currname = filepath.Join(outdir, currname)
-		if err != nil {
-			panic("Should never happen: Cannot find package name, not producing test executable")
+		if newgocmd.Params == nil {
+			newgocmd.Params = []string{"."}
 		}
-		if fl.Lookup("x").Value.String() == "true" {
-			log.Println("mv", currname, finalname)
+		if newgocmd.BuildFlags["c"] == "true" && newgocmd.BuildFlags["o"] == "" {
+			name, _, err := gocmd.OutputFileName()
+			if err != nil {
+				return err
+			}
+			workdir, err := filepath.Abs(gocmd.WorkDir)
+			if err != nil {
+				return err
+			}
+			newgocmd.BuildFlags["o"] = filepath.Join(workdir, name)
 		}
-		if err := os.Rename(currname, finalname); err != nil {
+	}
+	// go run would run the program in the instrumented directory, and does not pass on being
+	// killed to it, so we build the program and run it ourselves instead. It is built into a
+	// directory of its own, as the instrumented directory may be kept in the cache.
+	var program *exec.Cmd
+	if newgocmd.Command == "run" {
+		bindir, err := ioutil.TempDir("", tempStem)
+		if err != nil {
 			return err
 		}
-		if !minusC {
-			defer os.Remove(finalname)
-			for _, flag := range TestFlags {
-				v, ok := newgocmd.BuildFlags[flag]
-				if !ok {
-					v, ok = newgocmd.BuildFlags["test."+flag]
-				}
-				if ok {
-					newgocmd.ExtraFlags = append(newgocmd.ExtraFlags, "-test."+flag+"="+v)
-				}
-			}
-			r := exec.Command(finalname, newgocmd.ExtraFlags...)
-			r.Dir = gocmd.WorkDir
-			r.Stdin = os.Stdin
-			r.Stdout = os.Stdout
-			r.Stderr = os.Stderr
-			if err := r.Run(); err != nil {
+		defer os.RemoveAll(bindir)
+		name := filepath.Join(bindir, strings.TrimSuffix(filepath.Base(gocmd.Params[0]), ".go"))
+		if !runfiles {
+			dir, err := filepath.Abs(pkg.pkg.Dir)
+			if err != nil {
 				return err
 			}
+			name = filepath.Join(bindir, filepath.Base(dir))
 		}
+		program = exec.Command(name, newgocmd.ExtraFlags...)
+		if script != "" {
+			program.Args[0] = script
+		}
+		newgocmd.Command = "build"
+		newgocmd.BuildFlags["o"] = name
+		newgocmd.ExtraFlags = nil
+	}
+	if fl.Lookup("x").Value.String() == "true" {
+		log.Println("In:", newgocmd.WorkDir)
+		log.Println("Executing:", newgocmd)
+	}
+	runnable := newgocmd.Runnable()
+	// compile errors, vet warnings and test failures should point to the original files and packages
+	names, err := instrumentedNames([]*Instrumentable{pkg}, outdir)
+	if err != nil {
+		return err
+	}
+	stdout, err := newReportWriter(os.Stdout, outdir, gocmd.WorkDir, pkg.sources, pkg.sourcemaps)
+	if err != nil {
+		return err
+	}
+	reports, err := newReportWriter(os.Stderr, outdir, gocmd.WorkDir, pkg.sources, pkg.sourcemaps)
+	if err != nil {
+		return err
+	}
+	stdout.names, reports.names = names, names
+	runnable.Stdout = stdout
+	runnable.Stderr = reports
+	err = runGroupContext(ctx, runnable)
+	stdout.Flush()
+	reports.Flush()
+	if err != nil {
+		return err
+	}
+	if program != nil {
+		program.Dir = gocmd.WorkDir
+		program.Stdin = os.Stdin
+		program.Stdout = os.Stdout
+		// panics should point to the original files as well
+		program.Stderr = reports
+		defer reports.Flush()
+		return runContext(ctx, program)
 	}
 	return nil
 }
+
+// importArgs gives the Instrumentable described by command line arguments, either a
+// package, a list of go files, or the package in current directory if args is empty.
+func importArgs(args []string) (*Instrumentable, error) {
+	switch {
+	case len(args) == 0:
+		return ImportDir("", ".")
+	case len(args) > 1 || strings.HasSuffix(args[0], ".go"):
+		return ImportFiles("", args...), nil
+	case build.IsLocalImport(args[0]):
+		return ImportDir("", args[0])
+	}
+	mod, err := findModule(".")
+	if err != nil {
+		return nil, err
+	}
+	if mod != nil && mod.contains(args[0]) {
+		return mod.importPkg("", args[0])
+	}
+	return Import("", args[0])
+}
@@ -0,0 +1,148 @@
+package instrument
+
+import (
+	"bytes"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// wrapScript turns src, a script named filename, into a main package. A script is a go file
+// with no package clause, that may start with a #! line, e.g.
+//     #!/usr/bin/env -S gosloppy script
+//     name := os.Args[1]
+//     fmt.Println("hello", name)
+// Imports, and declarations of constants, types, functions and methods, are moved to the top
+// level. Everything else, variable declarations included, is the body of func main, in the
+// order it appears in the script. Every part of the script is preceded by a line directive, so
+// errors and stack traces point to the script itself.
+func wrapScript(filename string, src []byte) ([]byte, error) {
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	parts, err := parseScript(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	decls, body := new(bytes.Buffer), new(bytes.Buffer)
+	for _, part := range parts {
+		w := body
+		if part.decl {
+			w = decls
+		}
+		fmt.Fprintf(w, "/*line %s:%d:%d*/%s\n", filename, part.pos.Line, part.pos.Column, part.text)
+	}
+	return []byte("package main\n\n" + decls.String() + "\nfunc main() {\n" + body.String() + "}\n"), nil
+}
+
+// scriptPart is a statement, or a top level declaration, of a script
+type scriptPart struct {
+	pos  token.Position
+	text string
+	decl bool
+}
+
+// parseScript splits the script src, named filename, into its statements and declarations
+func parseScript(filename string, src []byte) (parts []scriptPart, err error) {
+	if bytes.HasPrefix(src, []byte("#!")) {
+		// the #! line is a comment, so that offsets and lines are those of the script
+		src = append([]byte("//"), src[2:]...)
+	}
+	fset := token.NewFileSet()
+	file := fset.AddFile(filename, -1, len(src))
+	var errs scanner.ErrorList
+	var s scanner.Scanner
+	s.Init(file, src, func(pos token.Position, msg string) { errs.Add(pos, msg) }, 0)
+	stmt := []token.Token{}
+	stmtpos, depth := token.NoPos, 0
+	for {
+		pos, tok, _ := s.Scan()
+		if stmtpos == token.NoPos && tok != token.SEMICOLON && tok != token.EOF {
+			if tok == token.PACKAGE {
+				return nil, fmt.Errorf("%s: a script has no package clause", fset.Position(pos))
+			}
+			stmtpos = pos
+		}
+		switch tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		}
+		if stmtpos != token.NoPos && (depth == 0 && tok == token.SEMICOLON || tok == token.EOF) {
+			end := file.Offset(pos)
+			if tok == token.EOF {
+				end = len(src)
+			}
+			position := fset.Position(stmtpos)
+			parts = append(parts, scriptPart{position, string(src[position.Offset:end]), isDecl(stmt)})
+			stmt, stmtpos = stmt[:0], token.NoPos
+		} else if stmtpos != token.NoPos {
+			stmt = append(stmt, tok)
+		}
+		if tok == token.EOF {
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs.Err()
+	}
+	return parts, nil
+}
+
+// isDecl returns whether the statement made of toks is a top level declaration, that is, an
+// import, a constant, a type, a function or a method declaration. A method is told apart from a
+// function literal by the name following its receiver.
+func isDecl(toks []token.Token) bool {
+	if len(toks) == 0 {
+		return false
+	}
+	switch toks[0] {
+	case token.IMPORT, token.CONST, token.TYPE:
+		return true
+	case token.FUNC:
+		if len(toks) > 1 && toks[1] == token.IDENT {
+			return true
+		}
+		depth := 0
+		for i, tok := range toks[1:] {
+			switch tok {
+			case token.LPAREN:
+				depth++
+			case token.RPAREN:
+				depth--
+			}
+			if depth == 0 {
+				return i+3 < len(toks) && toks[i+2] == token.IDENT && toks[i+3] == token.LPAREN
+			}
+		}
+	}
+	return false
+}
+
+// wrapScriptFile writes the main package script is wrapped into, see wrapScript, to a new
+// temporary directory, and returns the go file it wrote
+func wrapScriptFile(script string) (gofile string, err error) {
+	src, err := ioutil.ReadFile(script)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := wrapScript(script, src)
+	if err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir("", "__gosloppy_script")
+	if err != nil {
+		return "", err
+	}
+	gofile = filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(gofile, wrapped, 0644); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return gofile, nil
+}
@@ -0,0 +1,51 @@
+package instrument
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrapScript(t *testing.T) {
+	script := "#!/usr/bin/env -S gosloppy script\n" +
+		"x := f(); y := 1 // comment\n" +
+		"func f() int { return 1 }\n" +
+		"type T int\n" +
+		"func (t T) M() {}\n" +
+		"func() { println(x) }()\n" +
+		"const (\n\tc = 1\n)"
+	wrapped, err := wrapScript("a.gos", []byte(script))
+	OrFail(err, t)
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "main.go", wrapped, 0)
+	if err != nil {
+		t.Fatal("Cannot parse wrapped script:", err, "\n"+string(wrapped))
+	}
+	decls := []string{}
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			decls = append(decls, decl.Name.Name)
+		case *ast.GenDecl:
+			decls = append(decls, decl.Tok.String())
+		}
+	}
+	expectEq("[f type M const main]", fmt.Sprint(decls), t)
+	body := file.Decls[len(file.Decls)-1].(*ast.FuncDecl).Body.List
+	if len(body) != 3 {
+		t.Fatal("Expected x, y and the function literal in main, got", len(body), "statements")
+	}
+	abs, err := filepath.Abs("a.gos")
+	OrFail(err, t)
+	for i, expected := range []string{abs + ":2:1", abs + ":2:11", abs + ":6:1"} {
+		if pos := fset.Position(body[i].Pos()).String(); pos != expected {
+			t.Error("Expected statement", i, "at", expected, "got", pos)
+		}
+	}
+	if _, err := wrapScript("a.gos", []byte("package main\n")); err == nil {
+		t.Error("Expected a script with a package clause to be rejected")
+	}
+}
@@ -0,0 +1,211 @@
+package instrument
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go/build"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elazarl/gosloppy/patch"
+)
+
+// pollInterval is how often watch checks the watched files for changes
+var pollInterval = 500 * time.Millisecond
+
+// watch runs the go command args, and runs it again whenever a source file of the instrumented
+// packages, or go.mod, changes. The program or tests the previous command runs are killed first.
+// It runs until it is interrupted, or args turn out to be an invalid command.
+func watch(fl *flag.FlagSet, f func(*patch.PatchableFile) patch.Patches, args ...string) error {
+	if len(args) < 2 || args[1] != "build" && args[1] != "test" && args[1] != "run" {
+		return errors.New("usage: watch build|test|run <switches>")
+	}
+	// the go tool runs in a process group of its own, see runGroupContext, which does not get
+	// the interrupts of the terminal
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	// every command parses args with a new flag set, the values of our flags are shared
+	own := []*flag.Flag{}
+	fl.VisitAll(func(f *flag.Flag) { own = append(own, f) })
+	newFlagSet := func() *flag.FlagSet {
+		runfl := flag.NewFlagSet(fl.Name(), flag.ContinueOnError)
+		for _, f := range own {
+			runfl.Var(f.Value, f.Name, f.Usage)
+		}
+		return runfl
+	}
+	gocmd, err := NewGoCmdWithFlags(newFlagSet(), ".", args...)
+	if err != nil {
+		return err
+	}
+	// the first run may fail before any file is instrumented, e.g. on a syntax error
+	w := new(watcher)
+	w.addDirs(gocmd.Params)
+	for {
+		runfl := newFlagSet()
+		// files that fail to instrument are not added, changes to them are still watched
+		prev := w
+		w = new(watcher)
+		w.add(prev.paths()...)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- instrumentCmd(ctx, runfl, f, w, args...) }()
+		for !w.changed() {
+			select {
+			case err := <-done:
+				if err != nil && len(w.paths()) == 0 {
+					cancel()
+					return err
+				}
+				if err != nil {
+					fmt.Println(err)
+				}
+				done = nil
+			case <-interrupt:
+				cancel()
+				if done != nil {
+					<-done
+				}
+				return errors.New("Interrupted")
+			case <-time.After(pollInterval):
+			}
+		}
+		cancel()
+		if done != nil {
+			<-done
+		}
+		log.Println("Files changed, running", args[1], "again")
+	}
+}
+
+// watcher polls files for changes
+type watcher struct {
+	mu sync.Mutex
+	// files are the watched files, with their state when they were added
+	files map[string]string
+}
+
+// state returns the modification time and size of a file, or those of the go files a directory
+// has, so that building a binary into it is not a change. It is empty for files that do not exist.
+func state(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if !info.IsDir() {
+		return fmt.Sprint(info.ModTime().UnixNano(), info.Size())
+	}
+	gofiles, _ := filepath.Glob(filepath.Join(path, "*.go"))
+	states := []string{}
+	for _, gofile := range gofiles {
+		states = append(states, gofile+" "+state(gofile))
+	}
+	return strings.Join(states, "\n")
+}
+
+// add watches paths, from their current state
+func (w *watcher) add(paths ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.files == nil {
+		w.files = make(map[string]string)
+	}
+	for _, path := range paths {
+		if _, ok := w.files[path]; !ok {
+			w.files[path] = state(path)
+		}
+	}
+}
+
+// addSources watches the original files of the packages instrumented with pkg, the directories
+// they are in, as files may be added to them, and go.mod
+func (w *watcher) addSources(pkg *Instrumentable) {
+	paths := []string{}
+	for _, orig := range pkg.sources {
+		paths = append(paths, orig, filepath.Dir(orig))
+	}
+	if pkg.module != nil {
+		paths = append(paths, filepath.Join(pkg.module.Dir, "go.mod"))
+	}
+	w.add(paths...)
+}
+
+// addDirs watches the directories of the packages or go files a go command is given, or
+// the current directory if there are none. Packages given by import path are watched once they
+// are instrumented.
+func (w *watcher) addDirs(params []string) {
+	if len(params) == 0 {
+		params = []string{"."}
+	}
+	for _, param := range params {
+		switch {
+		case strings.HasSuffix(param, ".go"):
+			w.add(filepath.Dir(param))
+		case build.IsLocalImport(param) || filepath.IsAbs(param):
+			w.add(strings.TrimSuffix(param, "/..."))
+		}
+	}
+}
+
+func (w *watcher) paths() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	paths := []string{}
+	for path := range w.files {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// changed returns whether any of the watched files changed since it was added
+func (w *watcher) changed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for path, was := range w.files {
+		if state(path) != was {
+			return true
+		}
+	}
+	return false
+}
+
+// runContext runs cmd, and kills it if ctx is done before it is over
+func runContext(ctx context.Context, cmd *exec.Cmd) error {
+	return runKillContext(ctx, cmd, func() error { return cmd.Process.Kill() })
+}
+
+// runGroupContext is runContext for the go tool, which runs programs of its own, e.g. the test
+// binaries of go test. Unless ctx is never done, cmd runs in a process group, so that they are
+// killed along with it.
+func runGroupContext(ctx context.Context, cmd *exec.Cmd) error {
+	if ctx.Done() == nil {
+		return cmd.Run()
+	}
+	return runKillContext(ctx, cmd, processGroup(cmd))
+}
+
+// runKillContext runs cmd, and calls kill if ctx is done before it is over
+func runKillContext(ctx context.Context, cmd *exec.Cmd, kill func() error) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			kill()
+		case <-exited:
+		}
+	}()
+	return cmd.Wait()
+}
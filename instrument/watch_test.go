@@ -0,0 +1,59 @@
+package instrument
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestWatcher(t *testing.T) {
+	OrFail(dir("test", file("a.go", "package a")).Build("."), t)
+	defer func() { OrFail(os.RemoveAll("test"), t) }()
+	w := new(watcher)
+	w.add("test/a.go", "test", "test/b.go")
+	if w.changed() {
+		t.Fatal("Nothing changed yet")
+	}
+	// a binary built into a watched directory is not a change
+	OrFail(ioutil.WriteFile("test/test", []byte("binary"), 0755), t)
+	if w.changed() {
+		t.Fatal("Expected non go files to be ignored")
+	}
+	OrFail(ioutil.WriteFile("test/a.go", []byte("package a;var a int"), 0644), t)
+	if !w.changed() {
+		t.Fatal("Expected a change to a.go to be noticed")
+	}
+	w = new(watcher)
+	w.add("test/a.go", "test", "test/b.go")
+	OrFail(ioutil.WriteFile("test/c.go", []byte("package a"), 0644), t)
+	if !w.changed() {
+		t.Fatal("Expected a new file to be noticed")
+	}
+	w = new(watcher)
+	w.add("test/a.go", "test", "test/b.go")
+	OrFail(ioutil.WriteFile("test/b.go", []byte("package a"), 0644), t)
+	if !w.changed() {
+		t.Fatal("Expected a file that did not exist to be noticed")
+	}
+}
+
+func TestWatcherDirs(t *testing.T) {
+	OrFail(dir("test", file("a.go", "package a"), dir("sub", file("b.go", "package b"))).Build("."), t)
+	defer func() { OrFail(os.RemoveAll("test"), t) }()
+	w := new(watcher)
+	w.addDirs([]string{"./test/sub/..."})
+	OrFail(ioutil.WriteFile("test/a.go", []byte("package a;var a int"), 0644), t)
+	if w.changed() {
+		t.Fatal("Expected test/a.go not to be watched")
+	}
+	OrFail(ioutil.WriteFile("test/sub/b.go", []byte("package b;var b int"), 0644), t)
+	if !w.changed() {
+		t.Fatal("Expected a change to a go file of a watched directory to be noticed")
+	}
+	w = new(watcher)
+	w.addDirs([]string{"test/a.go"})
+	OrFail(ioutil.WriteFile("test/a.go", []byte("package a"), 0644), t)
+	if !w.changed() {
+		t.Fatal("Expected a change to test/a.go to be noticed")
+	}
+}
@@ -0,0 +1,180 @@
+package patch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DiffContext is the number of unchanged lines surrounding each hunk written by FprintDiff
+var DiffContext = 3
+
+// FprintDiff applies patches to p, and writes a unified diff between the original file and
+// the patched file to w. Nothing is written if patches do not change the file.
+func (p *PatchableFile) FprintDiff(w io.Writer, patches []Patch) error {
+	buf := new(bytes.Buffer)
+	if _, err := p.FprintPatched(buf, p.All(), patches); err != nil {
+		return err
+	}
+	// FprintPatched writes only the text between the first and last token (or comment)
+	all := p.All()
+	start, end := p.Fset.Position(all.Pos()).Offset, p.Fset.Position(all.End()).Offset
+	patched := p.Orig[:start] + buf.String() + p.Orig[end:]
+	return fprintUnified(w, p.FileName, splitLines(p.Orig), splitLines(patched))
+}
+
+// splitLines splits s into lines, each line retains its terminating newline
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+type diffOp byte
+
+const (
+	diffEqual  diffOp = ' '
+	diffDelete diffOp = '-'
+	diffInsert diffOp = '+'
+)
+
+type diffLine struct {
+	op   diffOp
+	line string
+}
+
+// diffLines computes the shortest edit script from a to b, with Myers' O((N+M)D) algorithm.
+// Patches are usually small, so D is small as well.
+func diffLines(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[max+k-1] < v[max+k+1] {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, max)
+			}
+		}
+	}
+	panic("Should never happen: no edit script between two files")
+}
+
+func backtrack(trace [][]int, a, b []string, max int) []diffLine {
+	x, y := len(a), len(b)
+	var rev []diffLine
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevk int
+		if k == -d || k != d && v[max+k-1] < v[max+k+1] {
+			prevk = k + 1
+		} else {
+			prevk = k - 1
+		}
+		prevx := v[max+prevk]
+		prevy := prevx - prevk
+		for x > prevx && y > prevy {
+			x--
+			y--
+			rev = append(rev, diffLine{diffEqual, a[x]})
+		}
+		if d > 0 {
+			if x == prevx {
+				rev = append(rev, diffLine{diffInsert, b[prevy]})
+			} else {
+				rev = append(rev, diffLine{diffDelete, a[prevx]})
+			}
+		}
+		x, y = prevx, prevy
+	}
+	lines := make([]diffLine, len(rev))
+	for i, l := range rev {
+		lines[len(rev)-1-i] = l
+	}
+	return lines
+}
+
+// fprintUnified writes the difference between a and b as a unified diff of file name
+func fprintUnified(w io.Writer, name string, a, b []string) error {
+	lines := diffLines(a, b)
+	// aline[i], bline[i] are the indices in a and b of the i'th diff line
+	aline, bline := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	var changes []int
+	for i, l := range lines {
+		aline[i+1], bline[i+1] = aline[i], bline[i]
+		if l.op != diffInsert {
+			aline[i+1]++
+		}
+		if l.op != diffDelete {
+			bline[i+1]++
+		}
+		if l.op != diffEqual {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "--- %s\n+++ %s\n", name, name); err != nil {
+		return err
+	}
+	for len(changes) > 0 {
+		// group all changes which are close enough to share context lines
+		last := 0
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*DiffContext {
+			last++
+		}
+		start, end := changes[0]-DiffContext, changes[last]+DiffContext+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(lines) {
+			end = len(lines)
+		}
+		changes = changes[last+1:]
+		if _, err := fmt.Fprintf(w, "@@ -%s +%s @@\n",
+			hunkRange(aline[start], aline[end]), hunkRange(bline[start], bline[end])); err != nil {
+			return err
+		}
+		for _, l := range lines[start:end] {
+			txt := string(l.op) + l.line
+			if !strings.HasSuffix(txt, "\n") {
+				txt += "\n\\ No newline at end of file\n"
+			}
+			if _, err := io.WriteString(w, txt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func hunkRange(from, to int) string {
+	switch to - from {
+	case 0:
+		return fmt.Sprintf("%d,0", from)
+	case 1:
+		return fmt.Sprint(from + 1)
+	}
+	return fmt.Sprintf("%d,%d", from+1, to-from)
+}
@@ -0,0 +1,58 @@
+package patch
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"testing"
+)
+
+func TestDiffNoPatches(t *testing.T) {
+	patchable := parse(body, t)
+	buf := new(bytes.Buffer)
+	if err := patchable.FprintDiff(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Error("Expected no diff got:\n", buf.String())
+	}
+}
+
+func TestDiff(t *testing.T) {
+	code := "package main\n\nfunc f() {\n\ta := 1\n}\n\nfunc g() {}\n\nfunc h() {}\n\nfunc i() {}\n\nfunc j() {\n\tb := 1\n}"
+	patchable := parse(code, t)
+	patchable.FileName = "a.go"
+	f := patchable.File.Decls[0].(*ast.FuncDecl).Body.List[0]
+	j := patchable.File.Decls[4].(*ast.FuncDecl).Body.List[0]
+	buf := new(bytes.Buffer)
+	if err := patchable.FprintDiff(buf, Patches{Insert(f.End(), ";_ = a"), Insert(j.End(), ";_ = b")}); err != nil {
+		t.Fatal(err)
+	}
+	exp := "--- a.go\n+++ a.go\n" +
+		"@@ -1,7 +1,7 @@\n package main\n \n func f() {\n-\ta := 1\n+\ta := 1;_ = a\n }\n \n func g() {}\n" +
+		"@@ -11,5 +11,5 @@\n func i() {}\n \n func j() {\n-\tb := 1\n+\tb := 1;_ = b\n }\n\\ No newline at end of file\n"
+	if buf.String() != exp {
+		t.Errorf("Expected:\n%s\nGot:\n%s", exp, buf.String())
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	a := []string{"a", "b", "c", "a", "b", "b", "a"}
+	b := []string{"c", "b", "a", "b", "a", "c"}
+	lines := diffLines(a, b)
+	var froma, fromb []string
+	for _, l := range lines {
+		if l.op != diffInsert {
+			froma = append(froma, l.line)
+		}
+		if l.op != diffDelete {
+			fromb = append(fromb, l.line)
+		}
+	}
+	if len(lines)-len(a) != 2 || len(lines)-len(b) != 3 {
+		t.Error("Expected edit script of length 5, got", lines)
+	}
+	if fmt.Sprint(froma) != fmt.Sprint(a) || fmt.Sprint(fromb) != fmt.Sprint(b) {
+		t.Error("Edit script", lines, "does not transform", a, "to", b)
+	}
+}
@@ -0,0 +1,159 @@
+package patch
+
+import (
+	"go/ast"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AddImports returns patches adding import specs, e.g. `"os"` or `r "math/rand"`, to the first
+// import declaration of p, formatted as gofmt would. A parenthesized declaration gets the
+// specs in their sorted position, a single import is turned into a parenthesized one, and if
+// there are no imports, a declaration is added after the package clause.
+// Unlike inserting `; import "os"` after the package clause, it changes line numbers.
+func (p *PatchableFile) AddImports(specs ...string) Patches {
+	if len(specs) == 0 {
+		return Patches{}
+	}
+	specs = append([]string(nil), specs...)
+	sort.Sort(byImportPath(specs))
+	var decl *ast.GenDecl
+	// a new declaration follows the package clause, or the last import "C", whose preamble
+	// comment must stay right before it
+	after := p.File.Name.End()
+	for _, d := range p.File.Decls {
+		if d, ok := d.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+			if importsC(d) {
+				after = d.End()
+				continue
+			}
+			decl = d
+			break
+		}
+	}
+	switch {
+	case decl == nil:
+		if len(specs) == 1 {
+			return Patches{Insert(after, "\n\nimport "+specs[0])}
+		}
+		return Patches{Insert(after, "\n\nimport (\n\t"+strings.Join(specs, "\n\t")+"\n)")}
+	case !decl.Lparen.IsValid():
+		spec := decl.Specs[0].(*ast.ImportSpec)
+		all := append(specs, p.Get(spec))
+		sort.Sort(byImportPath(all))
+		// a trailing comment of the spec is moved into the parentheses along with it
+		end := spec.End()
+		if spec.Comment != nil {
+			end = spec.Comment.End()
+			for i := range all {
+				if all[i] == p.Get(spec) {
+					all[i] = p.Slice(spec.Pos(), end)
+				}
+			}
+		}
+		return Patches{ReplaceRange(spec.Pos(), end, "(\n\t"+strings.Join(all, "\n\t")+"\n)")}
+	}
+	patches := Patches{}
+	for _, spec := range specs {
+		patches = append(patches, p.insertImport(decl, spec))
+	}
+	return patches
+}
+
+// insertImport returns a patch adding spec to the parenthesized import declaration decl, on a
+// line of its own. Like goimports, standard library packages are added to the first group of
+// specs, and other packages to the last group not of the standard library, if there is one.
+// Within the group, spec is added before the first spec with a greater import path.
+func (p *PatchableFile) insertImport(decl *ast.GenDecl, spec string) Patch {
+	groups := p.importGroups(decl)
+	if len(groups) == 0 {
+		if start := p.lineStart(decl.Rparen); strings.TrimSpace(p.Slice(start, decl.Rparen)) == "" {
+			return Insert(start, "\t"+spec+"\n")
+		}
+		return Insert(decl.Rparen, "\n\t"+spec+"\n")
+	}
+	group := groups[0]
+	if !stdlibPath(importPath(spec)) {
+		for _, g := range groups {
+			if !stdlibPath(importPath(p.Get(g[0]))) {
+				group = g
+			}
+		}
+	}
+	for _, s := range group {
+		if importPath(p.Get(s)) > importPath(spec) {
+			pos := s.Pos()
+			if s.Doc != nil {
+				pos = s.Doc.Pos()
+			}
+			return Insert(p.lineStart(pos), "\t"+spec+"\n")
+		}
+	}
+	return Insert(p.lineEnd(group[len(group)-1].End()), "\n\t"+spec)
+}
+
+// importGroups returns the specs of decl split into groups separated by blank lines
+func (p *PatchableFile) importGroups(decl *ast.GenDecl) (groups [][]*ast.ImportSpec) {
+	lastLine := -1
+	for _, s := range decl.Specs {
+		s := s.(*ast.ImportSpec)
+		pos := s.Pos()
+		if s.Doc != nil {
+			pos = s.Doc.Pos()
+		}
+		if line := p.Fset.Position(pos).Line; len(groups) == 0 || line > lastLine+1 {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], s)
+		lastLine = p.Fset.Position(s.End()).Line
+	}
+	return groups
+}
+
+// importsC returns whether decl imports the pseudo package "C" of cgo
+func importsC(decl *ast.GenDecl) bool {
+	for _, s := range decl.Specs {
+		if s.(*ast.ImportSpec).Path.Value == `"C"` {
+			return true
+		}
+	}
+	return false
+}
+
+// stdlibPath returns whether importpath looks like a standard library package, that is, its
+// first element has no dot
+func stdlibPath(importpath string) bool {
+	return !strings.Contains(strings.SplitN(importpath, "/", 2)[0], ".")
+}
+
+func (p *PatchableFile) lineStart(pos token.Pos) token.Pos {
+	file := p.Fset.File(pos)
+	return file.LineStart(file.Line(pos))
+}
+
+// lineEnd returns the position of the end of the line pos is in, skipping a trailing comment
+func (p *PatchableFile) lineEnd(pos token.Pos) token.Pos {
+	file := p.Fset.File(pos)
+	offset := file.Offset(pos)
+	for offset < len(p.Orig) && p.Orig[offset] != '\n' {
+		offset++
+	}
+	return file.Pos(offset)
+}
+
+// importPath returns the import path of an import spec
+func importPath(spec string) string {
+	fields := strings.Fields(spec)
+	if path, err := strconv.Unquote(fields[len(fields)-1]); err == nil {
+		return path
+	}
+	return fields[len(fields)-1]
+}
+
+type byImportPath []string
+
+func (specs byImportPath) Len() int           { return len(specs) }
+func (specs byImportPath) Less(i, j int) bool { return importPath(specs[i]) < importPath(specs[j]) }
+func (specs byImportPath) Swap(i, j int)      { specs[i], specs[j] = specs[j], specs[i] }
@@ -0,0 +1,82 @@
+package patch
+
+import (
+	"bytes"
+	"go/format"
+	"testing"
+)
+
+var AddImportsCases = []struct {
+	body  string
+	specs []string
+	exp   string
+}{
+	{
+		"package main\n\nfunc main() {}\n",
+		[]string{`"os"`},
+		"package main\n\nimport \"os\"\n\nfunc main() {}\n",
+	},
+	{
+		"package main\n\nfunc main() {}\n",
+		[]string{`"os"`, `"fmt"`},
+		"package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\nfunc main() {}\n",
+	},
+	{
+		"package main\n\nimport \"os\"\n\nfunc main() {}\n",
+		[]string{`r "math/rand"`, `"fmt"`},
+		"package main\n\nimport (\n\t\"fmt\"\n\tr \"math/rand\"\n\t\"os\"\n)\n\nfunc main() {}\n",
+	},
+	{
+		"package main\n\nimport \"os\" // for Exit\n\nfunc main() {}\n",
+		[]string{`"fmt"`},
+		"package main\n\nimport (\n\t\"fmt\"\n\t\"os\" // for Exit\n)\n\nfunc main() {}\n",
+	},
+	{
+		"package main\n\nimport (\n\t\"fmt\"\n\t// os is needed\n\t\"os\"\n\n\t\"github.com/foo/bar\"\n)\n",
+		[]string{`"strings"`, `"bytes"`, `"io"`},
+		"package main\n\nimport (\n\t\"bytes\"\n\t\"fmt\"\n\t\"io\"\n\t// os is needed\n\t\"os\"\n\t\"strings\"\n\n\t\"github.com/foo/bar\"\n)\n",
+	},
+	{
+		"package main\n\nimport (\n\t\"fmt\"\n)\n",
+		[]string{`"os"`},
+		"package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n",
+	},
+	{
+		"package main\n\nimport (\n\t\"os\" // for Exit\n\n\t\"github.com/foo/bar\"\n)\n",
+		[]string{`"strings"`, `"example.com/baz"`, `"golang.org/x/net"`},
+		"package main\n\nimport (\n\t\"os\" // for Exit\n\t\"strings\"\n\n\t\"example.com/baz\"\n\t\"github.com/foo/bar\"\n\t\"golang.org/x/net\"\n)\n",
+	},
+	{
+		"package main\n\n// #include <stdio.h>\nimport \"C\"\n\nfunc main() {}\n",
+		[]string{`"os"`},
+		"package main\n\n// #include <stdio.h>\nimport \"C\"\n\nimport \"os\"\n\nfunc main() {}\n",
+	},
+	{
+		"package main\n\n// #include <stdio.h>\nimport \"C\"\n\nimport \"fmt\"\n",
+		[]string{`"os"`},
+		"package main\n\n// #include <stdio.h>\nimport \"C\"\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n",
+	},
+	{
+		"package main\n\nimport ()\n",
+		[]string{`"os"`},
+		"package main\n\nimport (\n\t\"os\"\n)\n",
+	},
+}
+
+func TestAddImports(t *testing.T) {
+	for i, c := range AddImportsCases {
+		patchable := parse(c.body, t)
+		buf := new(bytes.Buffer)
+		if _, err := patchable.FprintPatched(buf, patchable.All(), patchable.AddImports(c.specs...)); err != nil {
+			t.Fatal(err)
+		}
+		// All() ends at the last token
+		got := buf.String() + "\n"
+		if got != c.exp {
+			t.Errorf("Case #%d: Expected:\n%s\nGot:\n%s", i, c.exp, got)
+		}
+		if formatted, err := format.Source([]byte(got)); err != nil || string(formatted) != got {
+			t.Errorf("Case #%d: not gofmt formatted %v:\n%s", i, err, got)
+		}
+	}
+}
@@ -1,12 +1,16 @@
 package patch
 
 import (
+	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"io"
 	"io/ioutil"
+	"path/filepath"
 	"sort"
+	"strings"
 )
 
 // PatchableFile represents a parsed Go file. You can change the Patchable file,
@@ -17,6 +21,13 @@ type PatchableFile struct {
 	File     *ast.File
 	Fset     *token.FileSet
 	Orig     string
+	// Pkg is the package the file was parsed into, or nil
+	Pkg *PatchablePkg
+	// LineDirectives makes FprintPatched follow every patch adding or removing lines with a
+	// line directive, e.g. /*line /src/a.go:10:5*/, so compiler errors, panics and stack traces
+	// point to the original source after the patch.
+	LineDirectives bool
+	info           *types.Info
 }
 
 // Patch represents a change to a source file between StartPos() and EndPos()
@@ -86,6 +97,11 @@ func Replace(nd ast.Node, replacement string) Patch {
 	return &InsertPatch{BasePatch{nd.Pos(), nd.End()}, replacement}
 }
 
+// ReplaceRange returns a patch replacing the text between positions from and to with replacement
+func ReplaceRange(from, to token.Pos, replacement string) Patch {
+	return &InsertPatch{BasePatch{from, to}, replacement}
+}
+
 // Remove returns a patch removing a node from the Go source file
 func Remove(nd ast.Node) Patch {
 	return RemovePatch{nd}
@@ -93,7 +109,10 @@ func Remove(nd ast.Node) Patch {
 
 // ParsePatchable parses a singlefile, and return corresponding PatchabeFile
 func ParsePatchable(name string) (*PatchableFile, error) {
-	fset := token.NewFileSet()
+	return parsePatchable(token.NewFileSet(), name)
+}
+
+func parsePatchable(fset *token.FileSet, name string) (*PatchableFile, error) {
 	buf, err := ioutil.ReadFile(name)
 	if err != nil {
 		return nil, err
@@ -102,7 +121,7 @@ func ParsePatchable(name string) (*PatchableFile, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &PatchableFile{file.Name.Name, name, file, fset, string(buf)}, nil
+	return &PatchableFile{PkgName: file.Name.Name, FileName: name, File: file, Fset: fset, Orig: string(buf)}, nil
 }
 
 // Get returns text corresponding to nd `nd` in file
@@ -187,9 +206,33 @@ func write(oldn *int, err *error, w io.Writer, s string) {
 	}
 }
 
+// lineDirective returns a line directive setting the position of the text following it to pos
+func (p *PatchableFile) lineDirective(pos token.Pos) string {
+	position := p.Fset.Position(pos)
+	filename, err := filepath.Abs(position.Filename)
+	if err != nil {
+		filename = position.Filename
+	}
+	return fmt.Sprintf("/*line %s:%d:%d*/", filename, position.Line, position.Column)
+}
+
 // FprintPatched apply patches to p and write the result to w
 // Note: If patches contradicts each other, behaviour is undefined.
 func (p *PatchableFile) FprintPatched(w io.Writer, nd ast.Node, patches []Patch) (total int, err error) {
+	return p.fprintPatched(w, nd, patches, nil)
+}
+
+// FprintPatchedSourceMap is like FprintPatched, and returns a source map of the text written to w
+//     m, _ := patchable.FprintPatchedSourceMap(buf, patchable.All(), patches)
+//     fmt.Println("error at", m.OriginalPosition(42))
+func (p *PatchableFile) FprintPatchedSourceMap(w io.Writer, nd ast.Node, patches []Patch) (*SourceMap, error) {
+	m := newSourceMap(p)
+	_, err := p.fprintPatched(w, nd, patches, m)
+	return m, err
+}
+
+// fprintPatched is FprintPatched, recording the written text in m, unless it is nil
+func (p *PatchableFile) fprintPatched(w io.Writer, nd ast.Node, patches []Patch, m *SourceMap) (total int, err error) {
 	defer func() {
 		if r := recover(); r != nil && err == nil {
 			panic(r)
@@ -202,10 +245,15 @@ func (p *PatchableFile) FprintPatched(w io.Writer, nd ast.Node, patches []Patch)
 		if nd.Pos() <= patch.StartPos() && nd.End() >= patch.StartPos() {
 			pos := p.Fset.Position(patch.StartPos())
 			write(&total, &err, w, p.Orig[prev:pos.Offset])
+			m.add(prev, p.Orig[prev:pos.Offset], false)
+			newlines := strings.Contains(p.Slice(patch.StartPos(), patch.EndPos()), "\n")
 			switch patch := patch.(type) {
 			case *InsertPatch:
 				write(&total, &err, w, patch.Insert)
+				m.add(pos.Offset, patch.Insert, true)
+				newlines = newlines || strings.Contains(patch.Insert, "\n")
 			case *InsertNodePatch:
+				newlines = newlines || strings.Contains(p.Get(patch.Insert), "\n")
 				// TODO(elazar): check performance implications
 				noremove := Patches{}
 				for _, p := range patches {
@@ -215,13 +263,25 @@ func (p *PatchableFile) FprintPatched(w io.Writer, nd ast.Node, patches []Patch)
 					}
 					noremove = append(noremove, p)
 				}
-				p.FprintPatched(w, patch.Insert, noremove)
+				var n int
+				n, err = p.fprintPatched(w, patch.Insert, noremove, m)
+				total += n
+				if err != nil {
+					return
+				}
+			}
+			// there's nothing to point to after the last patch
+			if p.LineDirectives && newlines && patch.EndPos() < nd.End() {
+				directive := p.lineDirective(patch.EndPos())
+				write(&total, &err, w, directive)
+				m.add(p.Fset.Position(patch.EndPos()).Offset, directive, true)
 			}
 			prev = p.Fset.Position(patch.EndPos()).Offset
 		}
 	}
 	if prev < end.Offset {
 		write(&total, &err, w, p.Orig[prev:end.Offset])
+		m.add(prev, p.Orig[prev:end.Offset], false)
 	}
 	return
 }
@@ -6,6 +6,7 @@ import (
 	"go/parser"
 	"go/token"
 	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -15,7 +16,7 @@ func parse(code string, t *testing.T) *PatchableFile {
 	if err != nil {
 		t.Fatal("Cannot parse code", err)
 	}
-	return &PatchableFile{file.Name.Name, "", file, fset, code}
+	return &PatchableFile{PkgName: file.Name.Name, File: file, Fset: fset, Orig: code}
 }
 
 func TestPatchableFileNoPatches(t *testing.T) {
@@ -138,3 +139,48 @@ func TestInsertNode(t *testing.T) {
 		InsertNode(patchable.File.Name.Pos(), patchable.File.Decls[0]),
 	)
 }
+
+func TestLineDirectives(t *testing.T) {
+	code := "package main\n\nfunc f() {\n\ta := 1\n\tb := undefined\n}\n\nfunc g() {\n\tprintln()\n}\n"
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "/src/a.go", code, parser.ParseComments)
+	if err != nil {
+		t.Fatal("Cannot parse code", err)
+	}
+	patchable := &PatchableFile{PkgName: file.Name.Name, FileName: "/src/a.go", File: file, Fset: fset, Orig: code, LineDirectives: true}
+	body := file.Decls[0].(*ast.FuncDecl).Body
+	a, b := body.List[0], body.List[1]
+	for i, patches := range []Patches{
+		{Insert(a.End(), "\n\tprintln(a)\n\tprintln(a)")},
+		{ReplaceRange(a.Pos(), b.Pos(), "")},
+		{Insert(b.Pos(), "_ = a\n\t"), Insert(b.Pos(), "_ = a\n\t")},
+		{InsertNode(b.Pos(), file.Decls[1].(*ast.FuncDecl).Body), Insert(b.Pos(), "\n\t")},
+	} {
+		buf := new(bytes.Buffer)
+		if _, err := patchable.FprintPatched(buf, patchable.All(), patches); err != nil {
+			t.Fatal(err)
+		}
+		patchedfset := token.NewFileSet()
+		patched, err := parser.ParseFile(patchedfset, "/tmp/patched.go", buf.Bytes(), 0)
+		if err != nil {
+			t.Fatal("Cannot parse patched code", err, buf.String())
+		}
+		ast.Inspect(patched, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && id.Name == "undefined" {
+				if pos := patchedfset.Position(id.Pos()).String(); pos != "/src/a.go:5:7" {
+					t.Errorf("Case #%d: expected undefined at /src/a.go:5:7, got %s in:\n%s", i, pos, buf.String())
+				}
+				return false
+			}
+			return true
+		})
+	}
+	patchable.LineDirectives = false
+	buf := new(bytes.Buffer)
+	if _, err := patchable.FprintPatched(buf, patchable.All(), Patches{Insert(a.End(), "\n")}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "line") {
+		t.Error("Unexpected line directive in", buf.String())
+	}
+}
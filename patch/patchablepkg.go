@@ -3,6 +3,9 @@ package patch
 import (
 	"go/ast"
 	"go/build"
+	"go/token"
+	"go/types"
+	"sync"
 )
 
 // PathablePkg represents a package of patchable files
@@ -10,6 +13,9 @@ type PatchablePkg struct {
 	Name  string
 	Scope *ast.Scope
 	Files map[string]*PatchableFile
+	// Fset is shared by all files of the package, so that they can be type checked together
+	Fset *token.FileSet
+	info *types.Info
 	// Imports not used, since I don't want to parse all imports
 	// Imports map[string]PatchablePkg
 }
@@ -19,6 +25,7 @@ type PatchablePkg struct {
 func ParsePackage(buildpkg *build.Package) (pkg *PatchablePkg, testpkg *PatchablePkg, err error) {
 	pkg = NewPatchablePkg()
 	testpkg = NewPatchablePkg()
+	testpkg.Fset = pkg.Fset
 	if err := pkg.ParseFiles(buildpkg.GoFiles...); err != nil {
 		return nil, nil, err
 	}
@@ -51,6 +58,7 @@ func NewPatchablePkg() *PatchablePkg {
 	return &PatchablePkg{
 		Scope: ast.NewScope(nil),
 		Files: make(map[string]*PatchableFile),
+		Fset:  token.NewFileSet(),
 	}
 }
 
@@ -64,12 +72,46 @@ func (pkg *PatchablePkg) ParseFiles(files ...string) error {
 	return nil
 }
 
+// ParseFilesParallel is like ParseFiles, but parses up to n files at once. Files are still added
+// to pkg in the given order, and the error returned is that of the first file that failed.
+func (pkg *PatchablePkg) ParseFilesParallel(n int, files ...string) error {
+	if n < 1 {
+		n = 1
+	}
+	parsed := make([]*PatchableFile, len(files))
+	errs := make([]error, len(files))
+	workers := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i, file := range files {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, file string) {
+			defer func() { <-workers; wg.Done() }()
+			// token.FileSet is safe for concurrent use
+			parsed[i], errs[i] = parsePatchable(pkg.Fset, file)
+		}(i, file)
+	}
+	wg.Wait()
+	for i, file := range files {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		pkg.add(file, parsed[i])
+	}
+	return nil
+}
+
 // ParseFile parses and adds a single file to pkg
 func (pkg *PatchablePkg) ParseFile(file string) error {
-	patchable, err := ParsePatchable(file)
+	patchable, err := parsePatchable(pkg.Fset, file)
 	if err != nil {
 		return err
 	}
+	pkg.add(file, patchable)
+	return nil
+}
+
+func (pkg *PatchablePkg) add(file string, patchable *PatchableFile) {
 	if pkg.Name != "" && pkg.Name != patchable.PkgName {
 		panic("ParsePkg called with files in two different packages. Had " +
 			pkg.Name + " got " + patchable.PkgName + " from " + file)
@@ -79,9 +121,9 @@ func (pkg *PatchablePkg) ParseFile(file string) error {
 		panic("File " + file + "parsed twice")
 	}
 	pkg.Files[file] = patchable
+	patchable.Pkg = pkg
 	for _, obj := range patchable.File.Scope.Objects {
 		pkg.Scope.Insert(obj)
 	}
 	patchable.File.Scope.Outer = pkg.Scope
-	return nil
 }
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -22,6 +23,25 @@ func TestScope(t *testing.T) {
 	ensureScope(t, pkg.Scope, "f", "foo", "p", "v")
 }
 
+func TestParseFilesParallel(t *testing.T) {
+	defer cleanUp()
+	files := []string{}
+	for i := 0; i < 10; i++ {
+		files = append(files, file(fmt.Sprintf("package main;var v%d = 1", i)))
+	}
+	pkg := NewPatchablePkg()
+	if err := pkg.ParseFilesParallel(3, files...); err != nil {
+		t.Fatal(err)
+	}
+	ensureScope(t, pkg.Scope, "v0", "v1", "v2", "v3", "v4", "v5", "v6", "v7", "v8", "v9")
+	// the error of the first broken file is reported
+	broken := []string{file("package main;var a = 1"), file("package main;var"), file("package main;func")}
+	err := NewPatchablePkg().ParseFilesParallel(3, broken...)
+	if err == nil || !strings.HasPrefix(err.Error(), broken[1]) {
+		t.Error("Expected error of", broken[1], "got", err)
+	}
+}
+
 var tempFiles []string
 
 func file(content string) (filename string) {
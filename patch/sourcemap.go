@@ -0,0 +1,130 @@
+package patch
+
+import (
+	"encoding/json"
+	"go/token"
+	"sort"
+)
+
+// SourceMap maps offsets in the text FprintPatchedSourceMap wrote, to offsets in the original
+// file. Text inserted by a patch is mapped to the position of the patch. It does not refer to
+// the original file, and can be stored as JSON.
+type SourceMap struct {
+	filename string
+	// origLineStarts are the offsets of the lines of the original file
+	origLineStarts []int
+	origSize       int
+	segments       []segment
+	// lineStarts are the offsets of the lines of the patched text
+	lineStarts []int
+	size       int
+}
+
+func newSourceMap(p *PatchableFile) *SourceMap {
+	return &SourceMap{filename: p.Fset.Position(p.File.Pos()).Filename, origLineStarts: lineStarts(p.Orig),
+		origSize: len(p.Orig), lineStarts: []int{0}}
+}
+
+func lineStarts(s string) []int {
+	starts := []int{0}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+// segment is text written either from the original file, or by a patch
+type segment struct {
+	// patched and orig are the offsets the segment starts at in the patched and original text
+	patched, orig int
+	// patch is set if the text was inserted by a patch, all of it is mapped to orig
+	patch bool
+}
+
+// add records text written from offset orig of the original file, or by a patch at orig
+func (m *SourceMap) add(orig int, text string, patch bool) {
+	if m == nil || text == "" {
+		return
+	}
+	m.segments = append(m.segments, segment{m.size, orig, patch})
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			m.lineStarts = append(m.lineStarts, m.size+i+1)
+		}
+	}
+	m.size += len(text)
+}
+
+// OriginalOffset returns the offset in the original file of offset patched in the patched text
+func (m *SourceMap) OriginalOffset(patched int) int {
+	i := sort.Search(len(m.segments), func(i int) bool { return m.segments[i].patched > patched }) - 1
+	if i < 0 {
+		return 0
+	}
+	seg := m.segments[i]
+	if seg.patch {
+		return seg.orig
+	}
+	if orig := seg.orig + patched - seg.patched; orig < m.origSize {
+		return orig
+	}
+	return m.origSize
+}
+
+// OriginalPosition returns the position in the original file of offset patched in the patched
+// text
+func (m *SourceMap) OriginalPosition(patched int) token.Position {
+	offset := m.OriginalOffset(patched)
+	line := sort.Search(len(m.origLineStarts), func(i int) bool { return m.origLineStarts[i] > offset })
+	return token.Position{Filename: m.filename, Offset: offset, Line: line, Column: offset - m.origLineStarts[line-1] + 1}
+}
+
+// Offset returns the offset in the patched text of a line and a column, both starting at 1, as
+// reported by the compiler
+func (m *SourceMap) Offset(line, col int) int {
+	if line < 1 {
+		return 0
+	}
+	if line > len(m.lineStarts) {
+		return m.size
+	}
+	return m.lineStarts[line-1] + col - 1
+}
+
+// jsonSourceMap is the JSON representation of a SourceMap, segments are flattened to triplets
+// of the patched offset, the original offset and 1 if the segment was inserted by a patch
+type jsonSourceMap struct {
+	Filename       string
+	OrigLineStarts []int
+	OrigSize       int
+	Segments       []int
+	LineStarts     []int
+	Size           int
+}
+
+func (m *SourceMap) MarshalJSON() ([]byte, error) {
+	segments := make([]int, 0, 3*len(m.segments))
+	for _, seg := range m.segments {
+		patch := 0
+		if seg.patch {
+			patch = 1
+		}
+		segments = append(segments, seg.patched, seg.orig, patch)
+	}
+	return json.Marshal(jsonSourceMap{m.filename, m.origLineStarts, m.origSize, segments, m.lineStarts, m.size})
+}
+
+func (m *SourceMap) UnmarshalJSON(b []byte) error {
+	var j jsonSourceMap
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*m = SourceMap{filename: j.Filename, origLineStarts: j.OrigLineStarts, origSize: j.OrigSize,
+		lineStarts: j.LineStarts, size: j.Size}
+	for i := 0; i+2 < len(j.Segments); i += 3 {
+		m.segments = append(m.segments, segment{j.Segments[i], j.Segments[i+1], j.Segments[i+2] == 1})
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/ast"
+	"strings"
+	"testing"
+)
+
+func TestSourceMap(t *testing.T) {
+	code := "package main\n\nfunc f() {\n\ta := 1\n\tb := 2\n}"
+	patchable := parse(code, t)
+	body := patchable.File.Decls[0].(*ast.FuncDecl).Body
+	a, b := body.List[0].(*ast.AssignStmt), body.List[1].(*ast.AssignStmt)
+	buf := new(bytes.Buffer)
+	m, err := patchable.FprintPatchedSourceMap(buf, patchable.All(), Patches{
+		Insert(a.End(), ";_ = a"),
+		InsertNode(b.Pos(), a),
+		Insert(b.Pos(), "\n\t"),
+		Replace(b.Lhs[0], "bb"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched := buf.String()
+	if exp := "package main\n\nfunc f() {\n\ta := 1;_ = a\n\ta := 1;_ = a\n\tbb := 2\n}"; patched != exp {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", exp, patched)
+	}
+	for _, c := range []struct {
+		// find is the first occurrence after offset from in the patched text
+		find      string
+		from      int
+		line, col int
+	}{
+		{"func", 0, 3, 1},
+		{"_ = a", 0, 4, 8},
+		{"\n", strings.Index(patched, "_ = a"), 4, 8},
+		{"1", strings.Index(patched, "_ = a"), 4, 7},
+		// the inserted node is mapped to the original node
+		{"a := 1", strings.LastIndex(patched, "\n\ta := 1;") + 1, 4, 2},
+		{"bb", 0, 5, 2},
+		{":= 2", 0, 5, 4},
+		{"}", 0, 6, 1},
+	} {
+		offset := c.from + strings.Index(patched[c.from:], c.find)
+		pos := m.OriginalPosition(offset)
+		if pos.Line != c.line || pos.Column != c.col {
+			t.Errorf("%q at %d: expected %d:%d got %d:%d", c.find, offset, c.line, c.col, pos.Line, pos.Column)
+		}
+	}
+	if offset := m.Offset(6, 5); patched[offset:offset+2] != ":=" {
+		t.Error("Expected := at 6:5 of the patched text, got", patched[offset:])
+	}
+	if m.OriginalOffset(len(patched)+10) != len(code) {
+		t.Error("Offsets past the end should map to the end of the original file")
+	}
+}
+
+func TestSourceMapJSON(t *testing.T) {
+	code := "package main\n\nfunc f() {\n\ta := 1\n}"
+	patchable := parse(code, t)
+	a := patchable.File.Decls[0].(*ast.FuncDecl).Body.List[0]
+	buf := new(bytes.Buffer)
+	m, err := patchable.FprintPatchedSourceMap(buf, patchable.All(), Patches{Insert(a.End(), ";_ = a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SourceMap
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for offset := 0; offset <= buf.Len(); offset++ {
+		if exp, got := m.OriginalPosition(offset), decoded.OriginalPosition(offset); exp != got {
+			t.Errorf("offset %d: expected %v got %v", offset, exp, got)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package patch
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// MissingImports returns the import specs, e.g. `r "math/rand"`, of the packages file uses
+// without importing them, which patches will import. If it is set, the file is type checked with
+// these imports, so that expressions using them have types.
+var MissingImports func(file *PatchableFile) []string
+
+// TypesInfo returns type information for the file. If the file belongs to a package, all the
+// package's files are type checked together. Sloppy code, naturally, does not type check, so
+// type errors are ignored, and expressions whose type could not be determined are missing, or
+// have an invalid type.
+func (p *PatchableFile) TypesInfo() *types.Info {
+	if p.Pkg != nil {
+		return p.Pkg.TypesInfo()
+	}
+	if p.info == nil {
+		p.info = typeCheck(p.Fset, p.PkgName, p)
+	}
+	return p.info
+}
+
+// TypesInfo returns type information for all files of the package. The package is type checked
+// once, files parsed into it after the first call are ignored.
+func (pkg *PatchablePkg) TypesInfo() *types.Info {
+	if pkg.info == nil {
+		names := []string{}
+		for name := range pkg.Files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		files := []*PatchableFile{}
+		for _, name := range names {
+			files = append(files, pkg.Files[name])
+		}
+		pkg.info = typeCheck(pkg.Fset, pkg.Name, files...)
+	}
+	return pkg.info
+}
+
+func typeCheck(fset *token.FileSet, name string, files ...*PatchableFile) *types.Info {
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+		// Implicits holds the objects of unrenamed imports and type switch variables
+		Implicits: make(map[ast.Node]types.Object),
+	}
+	conf := types.Config{
+		// imported packages might be sloppy as well, so we can't rely on compiled export data
+		Importer:    importer.ForCompiler(fset, "source", nil),
+		FakeImportC: true,
+		Error:       func(err error) {},
+	}
+	astFiles := []*ast.File{}
+	for _, file := range files {
+		astFiles = append(astFiles, withMissingImports(file))
+	}
+	conf.Check(name, fset, astFiles, info)
+	return info
+}
+
+// withMissingImports returns the syntax tree of file, or if MissingImports gives imports it lacks,
+// a copy of it declaring them as well. The nodes of file are shared, so are their types.
+func withMissingImports(file *PatchableFile) *ast.File {
+	if MissingImports == nil {
+		return file.File
+	}
+	specs := MissingImports(file)
+	if len(specs) == 0 {
+		return file.File
+	}
+	decl := &ast.GenDecl{Tok: token.IMPORT}
+	for _, spec := range specs {
+		fields := strings.Fields(spec)
+		imp := &ast.ImportSpec{Path: &ast.BasicLit{Kind: token.STRING, Value: fields[len(fields)-1]}}
+		if len(fields) > 1 {
+			imp.Name = ast.NewIdent(fields[0])
+		}
+		decl.Specs = append(decl.Specs, imp)
+	}
+	copied := *file.File
+	copied.Decls = append([]ast.Decl{decl}, file.File.Decls...)
+	return &copied
+}
@@ -26,6 +26,43 @@ func walkFields(v Visitor, fields []*ast.Field, scope *ast.Scope) {
 	}
 }
 
+// walkTypeParams inserts the type parameters tparams to scope, and walks through their
+// constraints. All names are inserted before walking, since constraints can refer to type
+// parameters declared after them, e.g.
+//     func f[S ~[]E, E any](s S)
+func walkTypeParams(v Visitor, tparams *ast.FieldList, scope *ast.Scope) {
+	if tparams == nil {
+		return
+	}
+	for _, field := range tparams.List {
+		for _, name := range field.Names {
+			insertToScope(scope, name.Obj)
+		}
+	}
+	for _, field := range tparams.List {
+		WalkExpr(v, field.Type, scope)
+	}
+}
+
+// recvTypeParams returns the type parameters a method's receiver declares, e.g. T in
+//     func (l *List[T]) Len() int
+func recvTypeParams(recv *ast.FieldList) []ast.Expr {
+	if recv == nil || len(recv.List) == 0 {
+		return nil
+	}
+	typ := recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	switch typ := typ.(type) {
+	case *ast.IndexExpr:
+		return []ast.Expr{typ.Index}
+	case *ast.IndexListExpr:
+		return typ.Indices
+	}
+	return nil
+}
+
 // WalkExpr walks through nodes below expr with visitor v, assuming scope is expr's scope
 func WalkExpr(v Visitor, expr ast.Expr, scope *ast.Scope) {
 	if v = v.VisitExpr(scope, expr); v == nil {
@@ -56,6 +93,12 @@ func WalkExpr(v Visitor, expr ast.Expr, scope *ast.Scope) {
 	case *ast.IndexExpr:
 		WalkExpr(v, expr.X, scope)
 		WalkExpr(v, expr.Index, scope)
+	case *ast.IndexListExpr:
+		// instantiation of a generic type or function, e.g. Map[K, V]
+		WalkExpr(v, expr.X, scope)
+		for _, index := range expr.Indices {
+			WalkExpr(v, index, scope)
+		}
 	case *ast.SliceExpr:
 		WalkExpr(v, expr.X, scope)
 		if expr.Low != nil {
@@ -225,6 +268,7 @@ func WalkStmt(v Visitor, stmt ast.Stmt, scope *ast.Scope) (newscope *ast.Scope)
 		exitScopes(v, inner, scope, stmt)
 	case *ast.RangeStmt:
 		inner := scope
+		// for range []int{1, 2, 3} {} has neither Key nor token, and declares nothing
 		if stmt.Tok == token.ASSIGN {
 			WalkExpr(v, stmt.Key, scope)
 			// For example, in
@@ -239,7 +283,7 @@ func WalkStmt(v Visitor, stmt ast.Stmt, scope *ast.Scope) (newscope *ast.Scope)
 			if stmt.Value != nil {
 				insertToScope(inner, stmt.Value.(*ast.Ident).Obj)
 			}
-		} else {
+		} else if stmt.Key != nil {
 			panic("range statement must have := or = token")
 		}
 		WalkExpr(v, stmt.X, inner)
@@ -327,6 +371,18 @@ func WalkFile(v Visitor, file *ast.File) {
 			if d.Recv != nil && len(d.Recv.List) > 0 && len(d.Recv.List[0].Names) > 0 {
 				insertToScope(scope, d.Recv.List[0].Names[0].Obj)
 			}
+			for _, tparam := range recvTypeParams(d.Recv) {
+				if tparam, ok := tparam.(*ast.Ident); ok {
+					// the parser does not always resolve receiver type parameters
+					obj := tparam.Obj
+					if obj == nil {
+						obj = ast.NewObj(ast.Typ, tparam.Name)
+						obj.Decl = tparam
+					}
+					insertToScope(scope, obj)
+				}
+			}
+			walkTypeParams(w, d.Type.TypeParams, scope)
 			// Params is always non-nil, since we always have parens, and need to know their pos
 			walkFields(w, d.Type.Params.List, scope)
 			if d.Type.Results != nil {
@@ -354,7 +410,15 @@ func WalkFile(v Visitor, file *ast.File) {
 					}
 				case *ast.TypeSpec:
 					// TODO: think what to do with the name, see above
-					WalkExpr(w, spec.Type, file.Scope)
+					if spec.TypeParams == nil {
+						WalkExpr(w, spec.Type, file.Scope)
+						continue
+					}
+					// type parameters are in the scope of the type declaration only
+					scope := ast.NewScope(file.Scope)
+					walkTypeParams(w, spec.TypeParams, scope)
+					WalkExpr(w, spec.Type, scope)
+					w.ExitScope(scope, spec, true)
 				}
 			}
 		}
@@ -417,6 +417,18 @@ var ScopeOrderTestCases = []struct {
 	`,
 		[][]string{{"f"}, {"funscope"}, {}, {}, {"forscope"}},
 	},
+	{`
+		package scopes
+		func f(funscope int) {
+			/* empty stmt block */
+			for range m {
+				/* empty stmt block */
+				forscope := 1
+			}
+		}
+	`,
+		[][]string{{"f"}, {"funscope"}, {}, {}, {"forscope"}},
+	},
 	{`
 		package scopes
 		func f(funscope int) {
@@ -493,4 +505,84 @@ var ScopeOrderTestCases = []struct {
 	`,
 		[][]string{{"f"}, {"funscope"}, {}, {"init"}, {"funclitscope"}, { /* funclit stmt block */}},
 	},
+	{`
+		package scopes
+		func f[S ~[]E, E any](s S) {
+		}
+	`,
+		[][]string{{"f"}, {"E", "S", "s"}, {}},
+	},
+	{`
+		package scopes
+		type List[T any] struct {
+			next *List[T]
+			val  T
+		}
+		func (l *List[T]) Len() int {
+		}
+	`,
+		[][]string{{"List"}, {"T", "l"}, {}, {"T"}},
+	},
+	{`
+		package scopes
+		type Pair[K comparable, V any] struct{}
+		func (Pair[K, V]) f() {
+		}
+	`,
+		[][]string{{"Pair"}, {"K", "V"}, {}, {"K", "V"}},
+	},
+}
+
+// TypeParamsResolved fails the test if a type parameter name is not in scope where it is used
+type TypeParamsResolved testing.T
+
+func (t *TypeParamsResolved) VisitExpr(scope *ast.Scope, expr ast.Expr) Visitor {
+	if ident, ok := expr.(*ast.Ident); ok && len(ident.Name) == 1 && Lookup(scope, ident.Name) == nil {
+		(*testing.T)(t).Errorf("type parameter %s at %d not in scope", ident.Name, ident.Pos())
+	}
+	return t
+}
+
+func (t *TypeParamsResolved) VisitDecl(scope *ast.Scope, decl ast.Decl) Visitor {
+	return t
+}
+
+func (t *TypeParamsResolved) VisitStmt(scope *ast.Scope, stmt ast.Stmt) Visitor {
+	return t
+}
+
+func (t *TypeParamsResolved) ExitScope(scope *ast.Scope, node ast.Node, last bool) Visitor {
+	return t
+}
+
+func TestTypeParams(t *testing.T) {
+	file, _ := parse(`package scopes
+type Number interface {
+	~int | ~float64
+}
+type Set[T comparable] map[T]struct{}
+type Tree[K comparable, V any] struct {
+	left, right *Tree[K, V]
+	items       map[K]V
+}
+type Constraint[T any] interface {
+	~[]T | Set[int]
+	Get() T
+}
+func Sum[S ~[]N, N Number](s S) (total N) {
+	for _, n := range s {
+		total += N(n)
+	}
+	return Map[S, N](s, func(n N) N { return n })
+}
+func (t *Tree[K, V]) Get(k K) (v V) {
+	var tree *Tree[K, V] = t
+	return tree.items[k]
+}
+func (s Set[T]) Has(t T) bool {
+	_, ok := s[t]
+	return ok
+}
+`, t)
+	WalkFile((*TypeParamsResolved)(t), file)
 }
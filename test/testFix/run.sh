@@ -0,0 +1,21 @@
+#!/bin/bash
+
+# fix rewrites the files in place, so it fixes a copy
+FIXDIR=$(mktemp -d)
+trap "rm -rf $FIXDIR" EXIT
+printf 'module fixed\n' > $FIXDIR/go.mod
+# the first pass leaves a range with no variables, the second adds the import
+cat > $FIXDIR/a.go <<'GO'
+package main
+
+func main() {
+	for i, v := range []string{"SUCCESS"} {
+	}
+	fmt.Println("SUCCESS")
+}
+GO
+(cd $FIXDIR
+$GOSLOPPY fix || exit 1
+grep -q '"fmt"' a.go || exit 1
+go build -o fixed || exit 1
+check fixed) || die fixing
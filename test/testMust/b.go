@@ -13,7 +13,7 @@ func init() {
 var _, _, _ = must(elliptic.GenerateKey(elliptic.P224(), ConstWriter(0)))
 
 func mustStmtExpr() {
-	// TODO(elazar): support automatic detection of function's type
 	_ = must(fmt.Println("bobo"))
 	_ = must(url.Parse("http://example.com"))
+	_ = orlog(url.Parse("http://example.com/orlog"))
 }
@@ -3,6 +3,9 @@ package visitors
 import (
 	"go/ast"
 	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/elazarl/gosloppy/imports"
 	"github.com/elazarl/gosloppy/patch"
@@ -15,21 +18,179 @@ import (
 //     scopes.WalkFile(patchable.File, auto)
 //     patchable.FprintPatched(os.Stdout, patchable.All(), auto.Patches)
 func NewAutoImporter(file *ast.File) *AutoImporter {
-	auto := &AutoImporter{patch.Patches{}, make(map[*ast.Ident]bool), make(map[string]bool), file.Name.End()}
+	auto := &AutoImporter{Patches: patch.Patches{}, Irrelevant: make(map[*ast.Ident]bool),
+		m: make(map[string]bool), pkg: file.Name.End(), selected: make(map[*ast.Ident]string),
+		undefined: make(map[string]*undefinedName)}
 	for _, imp := range file.Imports {
 		auto.m[imports.GetNameOrGuess(imp)] = true
 	}
 	return auto
 }
 
+// NewLocalAutoImporter returns an AutoImporter that imports packages of the module, or the
+// GOPATH, file is in, in addition to the standard library. If another file in file's package
+// imports a package by the missing name, the same package is imported, even if the name is
+// ambiguous.
+func NewLocalAutoImporter(file *patch.PatchableFile) *AutoImporter {
+	auto := NewAutoImporter(file.File)
+	auto.file = file
+	auto.dir = filepath.Dir(file.Fset.Position(file.File.Pos()).Filename)
+	if local, err := imports.LocalPackages(auto.dir); err == nil {
+		auto.local = local
+	}
+	auto.siblings = make(map[string][]candidate)
+	if file.Pkg == nil {
+		return auto
+	}
+	seen := make(map[string]bool)
+	for _, sibling := range file.Pkg.Files {
+		if sibling == file {
+			continue
+		}
+		for _, imp := range sibling.File.Imports {
+			if anonymousImport(imp.Name) {
+				continue
+			}
+			name, spec := imports.GetNameOrGuess(imp), imp.Path.Value
+			if imp.Name != nil {
+				spec = imp.Name.Name + " " + spec
+			}
+			if !seen[spec] {
+				seen[spec] = true
+				auto.siblings[name] = append(auto.siblings[name], candidate{spec, auto.packageOf(imp.Path.Value)})
+			}
+		}
+	}
+	return auto
+}
+
+// MissingImports returns the import specs of the packages a LocalAutoImporter imports into file,
+// see patch.MissingImports
+func MissingImports(file *patch.PatchableFile) []string {
+	auto := NewLocalAutoImporter(file)
+	scopes.WalkFile(auto, file.File)
+	return auto.added
+}
+
 // AutoImporter is a visitor for scopes.Walk* functions, it generate patches to add missing
-// import statements from the standard library. Note that it will not add ambigious import
-// (i.e. template, which can either be text/template or html/template).
+// import statements from the standard library, or from local packages if created with
+// NewLocalAutoImporter. When a name could refer to more than one package (i.e. template, which
+// can either be text/template or html/template), the package exporting all the selectors used
+// with the name is imported. If there is no single such package, nothing is imported, and the
+// name is reported in Ambiguous. Patches are generated when the walk exits the file scope.
 type AutoImporter struct {
-	Patches    patch.Patches
+	Patches patch.Patches
+	// Warnings reports each added import
+	Warnings Warnings
+	// Ambiguous reports each undefined name that could refer to more than one package
+	Ambiguous Warnings
+	// InPlace adds the imports to the import declaration, formatted as gofmt would, rather than
+	// after the package clause, in the same line. It is meant for rewriting files in place, as
+	// it changes line numbers. It requires an AutoImporter created with NewLocalAutoImporter.
+	InPlace    bool
+	file       *patch.PatchableFile
 	Irrelevant map[*ast.Ident]bool
 	m          map[string]bool
 	pkg        token.Pos
+	// local are the packages of the module or GOPATH, by name
+	local map[string][]imports.Package
+	// dir is the directory of the file
+	dir string
+	// siblings are the packages imported by other files of the package, by name
+	siblings map[string][]candidate
+	// selected maps the X of a selector expression to the selected name, e.g. rand to Intn in
+	// rand.Intn
+	selected map[*ast.Ident]string
+	// undefined are the undefined names which might be packages, in order of appearance
+	undefined map[string]*undefinedName
+	order     []string
+	// added are the import specs of the imported packages
+	added []string
+}
+
+type undefinedName struct {
+	pos       token.Pos
+	selectors map[string]bool
+}
+
+// candidate is a package an undefined name might refer to
+type candidate struct {
+	// spec is the import spec importing the package, e.g. `r "math/rand"`
+	spec string
+	pkg  imports.Package
+}
+
+// packageOf returns the package with the given quoted import path
+func (v *AutoImporter) packageOf(path string) imports.Package {
+	if _, ok := imports.Stdlib[path]; ok {
+		return imports.StdlibPackage(path)
+	}
+	importpath, _ := strconv.Unquote(path)
+	for _, pkgs := range v.local {
+		for _, pkg := range pkgs {
+			if pkg.ImportPath == importpath {
+				return pkg
+			}
+		}
+	}
+	return imports.Package{ImportPath: importpath}
+}
+
+// candidates returns the packages that could be meant by the undefined identifier name, by order
+// of precedence. Packages imported by other files come first, and the standard library takes
+// precedence over local packages, so that a package named "errors" somewhere in $GOPATH will not
+// prevent importing the standard errors package.
+func (v *AutoImporter) candidates(name string) [][]candidate {
+	stdlib := []candidate{}
+	for _, path := range imports.RevStdlib[name] {
+		stdlib = append(stdlib, candidate{path, imports.StdlibPackage(path)})
+	}
+	local := []candidate{}
+	for _, pkg := range v.local[name] {
+		if pkg.CanImport(v.dir) {
+			local = append(local, candidate{strconv.Quote(pkg.ImportPath), pkg})
+		}
+	}
+	return [][]candidate{v.siblings[name], stdlib, local}
+}
+
+// exporting returns the candidates that export all of selectors. A candidate whose exports are
+// unknown is assumed to export them.
+func exporting(candidates []candidate, selectors map[string]bool) []candidate {
+	found := []candidate{}
+	for _, c := range candidates {
+		exports, err := c.pkg.Exports()
+		ok := true
+		for sel := range selectors {
+			if err == nil && !exports[sel] {
+				ok = false
+			}
+		}
+		if ok {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// importSpec returns the import spec of the single package that could be meant by the undefined
+// identifier name, given the selectors used with it. If more than one package could be meant,
+// it returns their import specs as ambiguous.
+func (v *AutoImporter) importSpec(name string, selectors map[string]bool) (spec string, ambiguous []string) {
+	for _, candidates := range v.candidates(name) {
+		found := exporting(candidates, selectors)
+		switch len(found) {
+		case 0:
+			continue
+		case 1:
+			return found[0].spec, nil
+		}
+		for _, c := range found {
+			ambiguous = append(ambiguous, c.spec)
+		}
+		return "", ambiguous
+	}
+	return "", nil
 }
 
 func (v *AutoImporter) VisitExpr(scope *ast.Scope, expr ast.Expr) scopes.Visitor {
@@ -38,13 +199,23 @@ func (v *AutoImporter) VisitExpr(scope *ast.Scope, expr ast.Expr) scopes.Visitor
 		if v.Irrelevant[expr] {
 			return v
 		}
-		if importname, ok := imports.RevStdlib[expr.Name]; ok && len(importname) == 1 &&
-			!v.m[expr.Name] && scopes.Lookup(scope, expr.Name) == nil {
-			v.m[expr.Name] = true // don't add it again
-			v.Patches = append(v.Patches, patch.Insert(v.pkg, "; import "+importname[0]))
+		if v.m[expr.Name] || scopes.Lookup(scope, expr.Name) != nil {
+			return v
+		}
+		undefined, ok := v.undefined[expr.Name]
+		if !ok {
+			undefined = &undefinedName{expr.Pos(), make(map[string]bool)}
+			v.undefined[expr.Name] = undefined
+			v.order = append(v.order, expr.Name)
+		}
+		if sel, ok := v.selected[expr]; ok {
+			undefined.selectors[sel] = true
 		}
 	case *ast.SelectorExpr:
 		v.Irrelevant[expr.Sel] = true
+		if x, ok := expr.X.(*ast.Ident); ok {
+			v.selected[x] = expr.Sel.Name
+		}
 	case *ast.KeyValueExpr:
 		// if we get a := struct {Count int} {Count: 1}, disregard Count
 		if id, ok := expr.Key.(*ast.Ident); ok {
@@ -63,5 +234,32 @@ func (v *AutoImporter) VisitStmt(scope *ast.Scope, stmt ast.Stmt) scopes.Visitor
 }
 
 func (v *AutoImporter) ExitScope(scope *ast.Scope, node ast.Node, last bool) scopes.Visitor {
+	if _, ok := node.(*ast.File); !ok {
+		return v
+	}
+	specs := []string{}
+	for _, name := range v.order {
+		undefined := v.undefined[name]
+		spec, ambiguous := v.importSpec(name, undefined.selectors)
+		if len(ambiguous) > 0 {
+			msg := "undefined: " + name + ", could be any of " + strings.Join(ambiguous, ", ")
+			v.Ambiguous = append(v.Ambiguous, Warning{undefined.pos, msg})
+		}
+		if spec == "" {
+			continue
+		}
+		v.m[name] = true
+		specs = append(specs, spec)
+		v.added = append(v.added, spec)
+		v.Warnings = append(v.Warnings, Warning{undefined.pos, "undefined: " + name + ", imported " + spec})
+	}
+	if v.InPlace && v.file != nil {
+		v.Patches = append(v.Patches, v.file.AddImports(specs...)...)
+	} else {
+		for _, spec := range specs {
+			v.Patches = append(v.Patches, patch.Insert(v.pkg, "; import "+spec))
+		}
+	}
+	v.order, v.undefined = nil, make(map[string]*undefinedName)
 	return v
 }
@@ -0,0 +1,272 @@
+package visitors
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/elazarl/gosloppy/patch"
+)
+
+// FixUnused is an UnusedVisitor generating patches that remove unused imports and local
+// variables, making the file conform to the spec. It is the opposite of PatchUnused, which
+// hides the unused objects from the compiler.
+//
+// A declaration is removed only when it is safe, that is, when its initial values have no side
+// effects. Otherwise unused variables are replaced with the blank identifier:
+//     a, b := 1, f() // a and b are unused
+//     // becomes
+//     _, _ = 1, f()
+type FixUnused struct {
+	file    *patch.PatchableFile
+	parents map[ast.Node]ast.Node
+	unused  map[*ast.Ident]bool
+	// decls are the nodes declaring unused objects, in the order they were reported
+	decls   []ast.Node
+	seen    map[ast.Node]bool
+	imports []*ast.ImportSpec
+}
+
+// NewFixUnused returns a FixUnused visitor for file. Use it with NewUnused
+//     fix := NewFixUnused(patchable)
+//     scopes.WalkFile(NewUnused(fix), patchable.File)
+//     patchable.FprintPatched(os.Stdout, patchable.All(), fix.Patches())
+func NewFixUnused(file *patch.PatchableFile) *FixUnused {
+	f := &FixUnused{file, make(map[ast.Node]ast.Node), make(map[*ast.Ident]bool), nil, make(map[ast.Node]bool), nil}
+	var stack []ast.Node
+	ast.Inspect(file.File, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		if len(stack) > 0 {
+			f.parents[n] = stack[len(stack)-1]
+		}
+		stack = append(stack, n)
+		return true
+	})
+	return f
+}
+
+func (f *FixUnused) addDecl(decl ast.Node, names ...*ast.Ident) {
+	for _, name := range names {
+		f.unused[name] = true
+	}
+	if !f.seen[decl] {
+		f.seen[decl] = true
+		f.decls = append(f.decls, decl)
+	}
+}
+
+func identOf(obj *ast.Object, exprs ...ast.Expr) *ast.Ident {
+	for _, expr := range exprs {
+		if id, ok := expr.(*ast.Ident); ok && id.Obj == obj {
+			return id
+		}
+	}
+	return nil
+}
+
+// UnusedObj records obj, if it is a local variable the compiler would complain about
+func (f *FixUnused) UnusedObj(obj *ast.Object, parent ast.Node) {
+	if obj.Kind != ast.Var {
+		return
+	}
+	switch parent := parent.(type) {
+	case *ast.File:
+		return
+	case *ast.RangeStmt:
+		if id := identOf(obj, parent.Key, parent.Value); id != nil {
+			f.addDecl(parent, id)
+		}
+		return
+	case *ast.TypeSwitchStmt:
+		if assign, ok := parent.Assign.(*ast.AssignStmt); ok && assign == obj.Decl {
+			f.addDecl(parent, assign.Lhs[0].(*ast.Ident))
+			return
+		}
+	}
+	switch decl := obj.Decl.(type) {
+	case *ast.AssignStmt:
+		if id := identOf(obj, decl.Lhs...); id != nil {
+			f.addDecl(decl, id)
+		}
+	case *ast.ValueSpec:
+		names := make([]ast.Expr, len(decl.Names))
+		for i, name := range decl.Names {
+			names[i] = name
+		}
+		if id := identOf(obj, names...); id != nil {
+			f.addDecl(decl, id)
+		}
+	}
+}
+
+// UnusedImport records an unused import statement
+func (f *FixUnused) UnusedImport(imp *ast.ImportSpec) {
+	f.imports = append(f.imports, imp)
+}
+
+// Patches returns the patches fixing all unused objects and imports reported so far
+func (f *FixUnused) Patches() (patches patch.Patches) {
+	for _, decl := range f.decls {
+		switch decl := decl.(type) {
+		case *ast.RangeStmt:
+			key := decl.Key.(*ast.Ident)
+			// a blank key is as unused, for _, v := range x { turns into for range x {
+			keyUnused := key.Name == "_" || f.unused[key]
+			valueUnused := decl.Value == nil || f.unused[decl.Value.(*ast.Ident)]
+			switch {
+			case keyUnused && valueUnused:
+				// for range x {
+				patches = append(patches, f.remove(decl.Key.Pos(), decl.Range))
+			case valueUnused:
+				// for k := range x {
+				patches = append(patches, f.remove(decl.Key.End(), decl.Value.End()))
+			default:
+				patches = append(patches, patch.Replace(decl.Key, "_"))
+			}
+		case *ast.TypeSwitchStmt:
+			assign := decl.Assign.(*ast.AssignStmt)
+			patches = append(patches, f.remove(assign.Pos(), assign.Rhs[0].Pos()))
+		case *ast.AssignStmt:
+			if f.allUnused(decl.Lhs) && sideEffectFree(decl.Rhs...) {
+				patches = append(patches, f.removeStmt(decl))
+				continue
+			}
+			patches = append(patches, f.blank(decl.Lhs)...)
+			// x, _ := f() does not compile if x was declared before
+			if !f.declaresUsed(decl) {
+				patches = append(patches, patch.ReplaceRange(decl.TokPos, decl.TokPos+2, "="))
+			}
+		case *ast.ValueSpec:
+			names := make([]ast.Expr, len(decl.Names))
+			for i, name := range decl.Names {
+				names[i] = name
+			}
+			gendecl := f.parents[decl].(*ast.GenDecl)
+			switch {
+			case !f.allUnused(names) || !sideEffectFree(decl.Values...):
+				patches = append(patches, f.blank(names)...)
+			case len(gendecl.Specs) == 1:
+				patches = append(patches, f.removeStmt(f.parents[gendecl]))
+			default:
+				patches = append(patches, f.removeStmt(decl))
+			}
+		}
+	}
+	removed := make(map[*ast.GenDecl]int)
+	for _, imp := range f.imports {
+		removed[f.parents[imp].(*ast.GenDecl)]++
+	}
+	for _, imp := range f.imports {
+		gendecl := f.parents[imp].(*ast.GenDecl)
+		if removed[gendecl] < len(gendecl.Specs) {
+			patches = append(patches, f.removeStmt(imp))
+		} else if removed[gendecl] > 0 {
+			patches = append(patches, f.removeDecl(gendecl))
+			removed[gendecl] = 0 // remove it only once
+		}
+	}
+	return patches
+}
+
+func (f *FixUnused) allUnused(exprs []ast.Expr) bool {
+	for _, expr := range exprs {
+		if id, ok := expr.(*ast.Ident); ok && id.Name != "_" && !f.unused[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// declaresUsed returns whether assign declares a variable that is not unused, that is, it
+// stays a short variable declaration once the unused ones are blank
+func (f *FixUnused) declaresUsed(assign *ast.AssignStmt) bool {
+	for _, expr := range assign.Lhs {
+		if id, ok := expr.(*ast.Ident); ok && id.Name != "_" && !f.unused[id] && id.Obj != nil && id.Obj.Decl == assign {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FixUnused) blank(exprs []ast.Expr) (patches patch.Patches) {
+	for _, expr := range exprs {
+		if id, ok := expr.(*ast.Ident); ok && f.unused[id] {
+			patches = append(patches, patch.Replace(id, "_"))
+		}
+	}
+	return patches
+}
+
+func (f *FixUnused) remove(from, to token.Pos) patch.Patch {
+	return patch.ReplaceRange(from, to, "")
+}
+
+// removeStmt removes nd, if it is the only thing in its lines, the lines are removed as well.
+func (f *FixUnused) removeStmt(nd ast.Node) patch.Patch {
+	file := f.file.Fset.File(nd.Pos())
+	start, end := file.Offset(nd.Pos()), file.Offset(nd.End())
+	linestart, lineend := start, end
+	for linestart > 0 && (f.file.Orig[linestart-1] == ' ' || f.file.Orig[linestart-1] == '\t') {
+		linestart--
+	}
+	for lineend < len(f.file.Orig) && (f.file.Orig[lineend] == ' ' || f.file.Orig[lineend] == '\t') {
+		lineend++
+	}
+	if (linestart == 0 || f.file.Orig[linestart-1] == '\n') &&
+		lineend < len(f.file.Orig) && f.file.Orig[lineend] == '\n' {
+		return f.remove(file.Pos(linestart), file.Pos(lineend+1))
+	}
+	return f.remove(nd.Pos(), nd.End())
+}
+
+// removeDecl removes the top level declaration decl, like removeStmt, and if it was on lines of
+// its own, the blank line separating it from the next declaration.
+func (f *FixUnused) removeDecl(decl ast.Node) patch.Patch {
+	removed := f.removeStmt(decl)
+	file := f.file.Fset.File(decl.Pos())
+	end := file.Offset(removed.EndPos())
+	if end > 0 && f.file.Orig[end-1] == '\n' && end < len(f.file.Orig) && f.file.Orig[end] == '\n' {
+		return f.remove(removed.StartPos(), file.Pos(end+1))
+	}
+	return removed
+}
+
+// sideEffectFree returns whether evaluating exprs can neither have side effects nor panic.
+// It is conservative, any function call is considered to have side effects.
+func sideEffectFree(exprs ...ast.Expr) bool {
+	for _, expr := range exprs {
+		switch expr := expr.(type) {
+		case *ast.Ident, *ast.BasicLit, *ast.FuncLit:
+		case *ast.ParenExpr:
+			if !sideEffectFree(expr.X) {
+				return false
+			}
+		case *ast.SelectorExpr:
+			// only qualified identifiers, a field selector might dereference a nil pointer
+			if x, ok := expr.X.(*ast.Ident); !ok || x.Obj != nil {
+				return false
+			}
+		case *ast.CompositeLit:
+			if !sideEffectFree(expr.Elts...) {
+				return false
+			}
+		case *ast.KeyValueExpr:
+			if !sideEffectFree(expr.Key, expr.Value) {
+				return false
+			}
+		case *ast.UnaryExpr:
+			if expr.Op == token.ARROW || !sideEffectFree(expr.X) {
+				return false
+			}
+		case *ast.BinaryExpr:
+			if expr.Op == token.QUO || expr.Op == token.REM || !sideEffectFree(expr.X, expr.Y) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package visitors
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/elazarl/gosloppy/patch"
+	"github.com/elazarl/gosloppy/scopes"
+)
+
+func parsePatchable(code string, t *testing.T) *patch.PatchableFile {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "a.go", code, parser.DeclarationErrors|parser.ParseComments)
+	if err != nil {
+		t.Fatal("Cannot parse code", err)
+	}
+	return &patch.PatchableFile{PkgName: file.Name.Name, FileName: "a.go", File: file, Fset: fset, Orig: code}
+}
+
+func TestFixUnused(t *testing.T) {
+	for i, c := range FixUnusedCases {
+		if *ncase != i && *ncase > 0 {
+			continue
+		}
+		file := parsePatchable(c.body, t)
+		fix := NewFixUnused(file)
+		scopes.WalkFile(NewUnused(fix), file.File)
+		buf := new(bytes.Buffer)
+		if _, err := file.FprintPatched(buf, file.All(), fix.Patches()); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != c.exp {
+			t.Errorf("Case #%d:\n%s\nExpected:\n%s\nGot:\n%s", i, c.body, c.exp, buf.String())
+		}
+	}
+}
+
+var FixUnusedCases = []struct {
+	body string
+	exp  string
+}{
+	{
+		"package main\nfunc f() {\n\ta := 1\n\tprintln()\n}",
+		"package main\nfunc f() {\n\tprintln()\n}",
+	},
+	{
+		"package main\nfunc f() {\n\ta, b := 1, 2\n\tprintln(b)\n}",
+		"package main\nfunc f() {\n\t_, b := 1, 2\n\tprintln(b)\n}",
+	},
+	{
+		"package main\nfunc f() {\n\ta, b := g()\n}",
+		"package main\nfunc f() {\n\t_, _ = g()\n}",
+	},
+	{
+		"package main\nfunc f() {\n\tvar a int\n\ta, b := g()\n\tprintln(a)\n\tif true {\n\t\ta, c := g()\n\t\tprintln(a)\n\t}\n}",
+		"package main\nfunc f() {\n\tvar a int\n\ta, _ = g()\n\tprintln(a)\n\tif true {\n\t\ta, _ := g()\n\t\tprintln(a)\n\t}\n}",
+	},
+	{
+		"package main\nfunc f() {\n\tvar a, b = 1, 2\n\tvar (\n\t\tc int\n\t\td = 1\n\t)\n\tvar e = g()\n\tprintln(d)\n}",
+		"package main\nfunc f() {\n\tvar (\n\t\td = 1\n\t)\n\tvar _ = g()\n\tprintln(d)\n}",
+	},
+	{
+		"package main\nfunc f() {\n\tfor k, v := range []int{} {\n\t}\n\tfor k, v := range []int{} {\n\t\tprintln(k)\n\t}\n\tfor k, v := range []int{} {\n\t\tprintln(v)\n\t}\n\tfor _, v := range []int{} {\n\t}\n}",
+		"package main\nfunc f() {\n\tfor range []int{} {\n\t}\n\tfor k := range []int{} {\n\t\tprintln(k)\n\t}\n\tfor _, v := range []int{} {\n\t\tprintln(v)\n\t}\n\tfor range []int{} {\n\t}\n}",
+	},
+	{
+		"package main\nfunc f(y interface{}) {\n\tswitch x := y.(type) {\n\t}\n\tif z := 1; true {\n\t}\n}",
+		"package main\nfunc f(y interface{}) {\n\tswitch y.(type) {\n\t}\n\tif ; true {\n\t}\n}",
+	},
+	{
+		"package main\nimport \"fmt\"\nfunc f() {}",
+		"package main\nfunc f() {}",
+	},
+	{
+		"package main\nimport (\n\t\"fmt\"\n\t\"os\"\n)\nvar unused = os.Args\nfunc f(c chan int) {\n\tselect {\n\tcase x := <-c:\n\t}\n}",
+		"package main\nimport (\n\t\"os\"\n)\nvar unused = os.Args\nfunc f(c chan int) {\n\tselect {\n\tcase _ = <-c:\n\t}\n}",
+	},
+}
@@ -6,8 +6,11 @@ import (
 	"github.com/elazarl/gosloppy/patch"
 )
 
+// PatchUnused is an UnusedVisitor that adds patches to exempt unused objects and imports
+// from the compiler's checks. Every patched object is reported in Warnings.
 type PatchUnused struct {
-	Patches patch.Patches
+	Patches  patch.Patches
+	Warnings Warnings
 }
 
 // TL;DR compareAssgn(x,y) should implement internalInterfacePointer(x) == internalInterfacePointer(y)
@@ -51,9 +54,11 @@ func (p *PatchUnused) UnusedObj(obj *ast.Object, parent ast.Node) {
 			return
 		}
 	}
-	if obj.Kind == ast.Fun {
+	// the compiler does not complain about unused functions, types or type parameters
+	if obj.Kind == ast.Fun || obj.Kind == ast.Typ {
 		return
 	}
+	p.Warnings = append(p.Warnings, Warning{obj.Pos(), obj.Name + " declared and not used"})
 	exempter := "_ = " + obj.Name
 	switch parent := parent.(type) {
 	case *ast.ForStmt:
@@ -93,6 +98,7 @@ func (p *PatchUnused) UnusedObj(obj *ast.Object, parent ast.Node) {
 
 // UnusedImport adds relevant patch (_ before import path) to fix unused import error
 func (p *PatchUnused) UnusedImport(imp *ast.ImportSpec) {
+	p.Warnings = append(p.Warnings, Warning{imp.Pos(), imp.Path.Value + " imported and not used"})
 	if imp.Name != nil {
 		p.Patches = append(p.Patches, patch.Replace(imp.Name, "_"))
 	} else {
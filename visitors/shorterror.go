@@ -1,10 +1,14 @@
 package visitors
 
 import (
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/token"
+	"go/types"
+	"path/filepath"
 	"strings"
+	"unicode"
 
 	"github.com/elazarl/gosloppy/patch"
 	"github.com/elazarl/gosloppy/scopes"
@@ -15,7 +19,14 @@ import (
 // with
 //     x, y, err := f()
 //     if err != nil {
-//	       panic(err)
+//	       panic("file.go:12: " + err.Error())
+//     }
+// Or, for ShortError returned by NewOrLog, statements like
+//     x, y := orlog(f())
+// with
+//     x, y, err := f()
+//     if err != nil {
+//	       log.Println("file.go:12:", err)
 //     }
 type ShortError struct {
 	file    *patch.PatchableFile
@@ -27,23 +38,54 @@ type ShortError struct {
 	block   *ast.BlockStmt
 	tmpvar  int
 	initTxt *[]byte
+	// keyword points to the name of the builtin, MustKeyword or OrLogKeyword
+	keyword *string
+	// orlog is set if errors should be logged instead of panicking
+	orlog bool
+	// usedLog is set when the log package must be imported
+	usedLog *bool
+	// errors are the misuses of the builtin, which are not patched
+	errors *Warnings
 }
 
-// NewShortError returns a shorterror instance relevant to file
+// NewShortError returns a shorterror instance relevant to file, that panics on errors
+// returned to the MustKeyword builtin
 func NewShortError(file *patch.PatchableFile) *ShortError {
 	v := ShortError{}
 	v.file = file
 	v.patches = new(patch.Patches)
 	v.stmt, v.block = nil, nil
 	v.initTxt = new([]byte)
+	v.keyword = &MustKeyword
+	v.usedLog = new(bool)
+	v.errors = new(Warnings)
 	return &v
 }
 
+// NewOrLog returns a shorterror instance relevant to file, that logs errors returned
+// to the OrLogKeyword builtin, with their original position, and continues.
+func NewOrLog(file *patch.PatchableFile) *ShortError {
+	v := NewShortError(file)
+	v.keyword = &OrLogKeyword
+	v.orlog = true
+	return v
+}
+
 func (v *ShortError) Patches() patch.Patches {
 	return *v.patches
 }
 
+// Errors returns the calls to the builtin that could not be patched, e.g. with the wrong number
+// of arguments, to be reported as compile errors, see Warnings.FprintErrors
+func (v *ShortError) Errors() Warnings {
+	return *v.errors
+}
+
 func (v *ShortError) tempVar(stem string, scope *ast.Scope) string {
+	if v.orlog {
+		// must and orlog patch the same file, neither sees the temporary variables of the other
+		stem = "log" + stem
+	}
 	for ; v.tmpvar < 10*1000; v.tmpvar++ {
 		name := fmt.Sprint(stem, v.tmpvar)
 		if scopes.Lookup(scope, name) == nil {
@@ -56,6 +98,73 @@ func (v *ShortError) tempVar(stem string, scope *ast.Scope) string {
 
 var MustKeyword = "must"
 
+var OrLogKeyword = "orlog"
+
+// logPkg is the name log package is imported with, when "orlog" is used
+const logPkg = "gosloppylog"
+
+// onError returns the statement handling the error errvar, returned from the call at pos.
+// The original position of the call is added to the panic or log message.
+func (v *ShortError) onError(pos token.Pos, errvar string) string {
+	position := v.file.Fset.Position(pos)
+	prefix := fmt.Sprint(position.Filename, ":", position.Line, ":")
+	if !v.orlog {
+		return fmt.Sprintf("panic(%q + %s.Error())", prefix+" ", errvar)
+	}
+	*v.usedLog = true
+	return fmt.Sprintf("%s.Println(%q, %s)", logPkg, prefix, errvar)
+}
+
+func (v *ShortError) isBuiltin(fun ast.Expr) bool {
+	name, ok := fun.(*ast.Ident)
+	return ok && name.Name == *v.keyword
+}
+
+func (v *ShortError) badArgs(pos token.Pos) {
+	v.errorf(pos, "'%s' builtin must be called with exactly one argument", *v.keyword)
+}
+
+func (v *ShortError) errorf(pos token.Pos, format string, args ...interface{}) {
+	*v.errors = append(*v.errors, Warning{pos, fmt.Sprintf(format, args...)})
+}
+
+var errUnknownResults = errors.New("cannot determine the results")
+
+// results returns the number of values a call to the builtin evaluates to, that is, the number
+// of results of its argument without the trailing error. It returns errUnknownResults if the
+// argument could not be type checked.
+func (v *ShortError) results(call *ast.CallExpr) (int, error) {
+	tv, ok := v.file.TypesInfo().Types[call.Args[0]]
+	if !ok || tv.Type == nil || tv.Type == types.Typ[types.Invalid] {
+		return 0, errUnknownResults
+	}
+	results, ok := tv.Type.(*types.Tuple)
+	if !ok {
+		results = types.NewTuple(types.NewVar(token.NoPos, nil, "", tv.Type))
+	}
+	if results.Len() == 0 {
+		return 0, fmt.Errorf("%s (no value) used as argument to '%s'", v.file.Get(call.Args[0]), *v.keyword)
+	}
+	last := results.At(results.Len() - 1).Type()
+	if last == types.Typ[types.Invalid] {
+		return 0, errUnknownResults
+	}
+	if !types.Identical(last, types.Universe.Lookup("error").Type()) {
+		return 0, fmt.Errorf("last result of %s is %s, not error, in argument to '%s'", v.file.Get(call.Args[0]), last, *v.keyword)
+	}
+	return results.Len() - 1, nil
+}
+
+// typeError reports err if the argument of call does not return an error, and returns whether
+// it was reported.
+func (v *ShortError) typeError(call *ast.CallExpr, err error) bool {
+	if err == nil || err == errUnknownResults {
+		return false
+	}
+	v.errorf(call.Args[0].Pos(), "%v", err)
+	return true
+}
+
 // Yeah yeah, O(n^2) in the worst case. If you use the "must" function so much
 // YOU are the worst case.
 func findinit(file *ast.File) *ast.FuncDecl {
@@ -71,30 +180,57 @@ func afterImports(file *ast.File) token.Pos {
 	return file.End()
 }
 
+// fileStem returns an identifier derived from the file name. Top level temporary variables must
+// not collide with the ones of other files in the package, which are not in our scope.
+func (v *ShortError) fileStem() string {
+	name := strings.TrimSuffix(filepath.Base(v.file.Fset.File(v.file.File.Pos()).Name()), ".go")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
 func (v *ShortError) addToInit(txt string) {
 	*v.initTxt = append(*v.initTxt, txt...)
 }
 
 func (v *ShortError) VisitExpr(scope *ast.Scope, expr ast.Expr) scopes.Visitor {
 	if expr, ok := expr.(*ast.CallExpr); ok {
-		if fun, ok := expr.Fun.(*ast.Ident); ok && fun.Name == MustKeyword {
+		if v.isBuiltin(expr.Fun) {
 			if len(expr.Args) != 1 {
-				pos := v.file.Fset.Position(fun.Pos())
-				fmt.Printf("%s:%d:%d: 'must' builtin must be called with exactly one argument\n", pos.Filename, pos.Line, pos.Column)
+				v.badArgs(expr.Fun.Pos())
+				return nil
+			}
+			n, err := v.results(expr)
+			if v.typeError(expr, err) {
 				return nil
 			}
-			tmpVar, tmpErr := v.tempVar("tmp_", scope), v.tempVar("err_", scope)
+			if err == errUnknownResults {
+				// TODO(elazarl): we assume one value, the compiler will complain if we're wrong
+				n = 1
+			}
+			if n == 0 {
+				v.errorf(expr.Pos(), "%s (no value) used as value", v.file.Get(expr))
+				return nil
+			}
+			tmpVars := []string{}
+			for i := 0; i < n; i++ {
+				tmpVars = append(tmpVars, v.tempVar("tmp_", scope))
+			}
+			tmpVar, tmpErr := strings.Join(tmpVars, ", "), v.tempVar("err_", scope)
 			mustexpr := v.file.Get(expr.Args[0])
 			if v.block == nil {
 				// if in top level decleration
-				v.addToInit("if " + tmpErr + " != nil {panic(" + tmpErr + ")};")
+				v.addToInit("if " + tmpErr + " != nil {" + v.onError(expr.Pos(), tmpErr) + "};")
 				*v.patches = append(*v.patches,
 					patch.Replace(expr, tmpVar),
 					patch.Insert(afterImports(v.file.File), ";var "+tmpVar+", "+tmpErr+" = "+mustexpr))
 			} else {
 				*v.patches = append(*v.patches, patch.Insert(v.stmt.Pos(),
 					fmt.Sprint("var ", tmpVar, ", ", tmpErr, " = ", mustexpr, "; ",
-						"if ", tmpErr, " != nil {panic(", tmpErr, ")};")))
+						"if ", tmpErr, " != nil {", v.onError(expr.Pos(), tmpErr), "};")))
 				*v.patches = append(*v.patches, patch.Replace(expr, tmpVar))
 			}
 		}
@@ -108,19 +244,21 @@ func (v *ShortError) VisitDecl(scope *ast.Scope, decl ast.Decl) scopes.Visitor {
 			// We'll act only in cases like top level `var a, b, c = must(expr)`
 			if spec, ok := spec.(*ast.ValueSpec); ok && len(spec.Values) == 1 {
 				if fun, ok := spec.Values[0].(*ast.CallExpr); ok {
-					if name, ok := fun.Fun.(*ast.Ident); !ok || name.Name != MustKeyword {
+					if !v.isBuiltin(fun.Fun) {
 						return v
 					}
 					if len(fun.Args) != 1 {
-						pos := v.file.Fset.Position(fun.Pos())
-						fmt.Printf("%s:%d:%d: 'must' builtin must be called with exactly one argument\n", pos.Filename, pos.Line, pos.Column)
+						v.badArgs(fun.Pos())
 						return nil
 					}
-					tmpErr := v.tempVar("tlderr_", scope)
+					if _, err := v.results(fun); v.typeError(fun, err) {
+						return nil
+					}
+					tmpErr := v.tempVar("tlderr_"+v.fileStem()+"_", scope)
 					*v.patches = append(*v.patches,
 						patch.Insert(spec.Names[len(spec.Names)-1].End(), ", "+tmpErr),
 						patch.Replace(fun, v.file.Get(fun.Args[0])))
-					v.addToInit("if " + tmpErr + " != nil { panic(" + tmpErr + ") }")
+					v.addToInit("if " + tmpErr + " != nil { " + v.onError(fun.Pos(), tmpErr) + " };")
 				}
 			}
 		}
@@ -129,12 +267,12 @@ func (v *ShortError) VisitDecl(scope *ast.Scope, decl ast.Decl) scopes.Visitor {
 	return v
 }
 
-func calltomust(expr ast.Expr) *ast.CallExpr {
+func (v *ShortError) calltobuiltin(expr ast.Expr) *ast.CallExpr {
 	callexpr, ok := expr.(*ast.CallExpr)
 	if !ok {
 		return nil
 	}
-	if fun, ok := callexpr.Fun.(*ast.Ident); ok && fun.Name == MustKeyword {
+	if v.isBuiltin(callexpr.Fun) {
 		return callexpr
 	}
 	return nil
@@ -144,20 +282,48 @@ func (v *ShortError) VisitStmt(scope *ast.Scope, stmt ast.Stmt) scopes.Visitor {
 	v.stmt = stmt
 	switch stmt := stmt.(type) {
 	case *ast.BlockStmt:
-		return &ShortError{v.file, v.patches, v.stmt, stmt, 0, new([]byte)}
+		return &ShortError{v.file, v.patches, v.stmt, stmt, 0, new([]byte), v.keyword, v.orlog, v.usedLog, v.errors}
 	case *ast.ExprStmt:
-		if call := calltomust(stmt.X); call != nil {
-			// TODO(elazarl): depends on number of variables it returns, currently we assume one
-			pos := v.file.Fset.Position(stmt.Pos())
-			fmt.Printf("%s:%d:%d: 'must' builtin must be assigned into variable\n",
-				pos.Filename, pos.Line, pos.Column)
+		if call := v.calltobuiltin(stmt.X); call != nil {
+			if len(call.Args) != 1 {
+				v.badArgs(call.Fun.Pos())
+				return nil
+			}
+			n, err := v.results(call)
+			if v.typeError(call, err) {
+				return nil
+			}
+			if err == errUnknownResults {
+				v.errorf(stmt.Pos(), "'%s' builtin must be assigned into variable, unless its argument type is known", *v.keyword)
+				return nil
+			}
+			// must(f()) becomes `if _, err := (f()); err != nil { panic(err) }`
+			vars := []string{}
+			for i := 0; i < n; i++ {
+				vars = append(vars, "_")
+			}
+			tmpErr := v.tempVar("err_", scope)
+			v.VisitExpr(scope, call.Args[0])
+			*v.patches = append(*v.patches,
+				patch.Insert(stmt.Pos(), "if "+strings.Join(append(vars, tmpErr), ", ")+" := "),
+				patch.Replace(call.Fun, ""),
+				patch.Insert(stmt.End(), "; "+tmpErr+" != nil { "+v.onError(call.Pos(), tmpErr)+" }"),
+			)
+			return nil
 		}
 	case *ast.AssignStmt:
 		if len(stmt.Rhs) != 1 {
 			return v
 		}
 		if rhs, ok := stmt.Rhs[0].(*ast.CallExpr); ok {
-			if fun, ok := rhs.Fun.(*ast.Ident); ok && fun.Name == MustKeyword {
+			if fun, ok := rhs.Fun.(*ast.Ident); ok && v.isBuiltin(fun) {
+				if len(rhs.Args) != 1 {
+					v.badArgs(fun.Pos())
+					return nil
+				}
+				if _, err := v.results(rhs); v.typeError(rhs, err) {
+					return nil
+				}
 				if stmt.Tok == token.DEFINE {
 					tmpVar := v.tempVar("assignerr_", scope)
 					*v.patches = append(*v.patches,
@@ -165,7 +331,7 @@ func (v *ShortError) VisitStmt(scope *ast.Scope, stmt ast.Stmt) scopes.Visitor {
 						patch.Replace(fun, ""),
 						patch.Insert(stmt.End(),
 							"; if "+tmpVar+" != nil "+
-								"{ panic("+tmpVar+") };"),
+								"{ "+v.onError(rhs.Pos(), tmpVar)+" };"),
 					)
 					for _, arg := range rhs.Args {
 						v.VisitExpr(scope, arg)
@@ -184,7 +350,7 @@ func (v *ShortError) VisitStmt(scope *ast.Scope, stmt ast.Stmt) scopes.Visitor {
 						patch.InsertNode(stmt.Pos(), rhs.Args[0]),
 						patch.Insert(stmt.Pos(),
 							"; if "+assgnerr+" != nil "+
-								"{ panic("+assgnerr+") };"),
+								"{ "+v.onError(rhs.Pos(), assgnerr)+" };"),
 						patch.Replace(rhs, strings.Join(vars, ", ")),
 					)
 					v.VisitExpr(scope, rhs.Args[0])
@@ -197,6 +363,9 @@ func (v *ShortError) VisitStmt(scope *ast.Scope, stmt ast.Stmt) scopes.Visitor {
 }
 
 func (v *ShortError) ExitScope(scope *ast.Scope, node ast.Node, last bool) scopes.Visitor {
+	if node, ok := node.(*ast.File); ok && *v.usedLog {
+		*v.patches = append(*v.patches, patch.Insert(node.Name.End(), "; import "+logPkg+` "log"`))
+	}
 	if node, ok := node.(*ast.File); ok && len(*v.initTxt) > 0 {
 		if init := findinit(node); init != nil {
 			*v.patches = append(*v.patches, patch.Insert(init.Body.Lbrace+1, string(*v.initTxt)))
@@ -0,0 +1,143 @@
+package visitors
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/elazarl/gosloppy/patch"
+	"github.com/elazarl/gosloppy/scopes"
+)
+
+func TestShortError(t *testing.T) {
+	for i, c := range ShortErrorCases {
+		if *ncase != i && *ncase > 0 {
+			continue
+		}
+		file := parsePatchable(c.body, t)
+		v := NewShortError(file)
+		if c.orlog {
+			v = NewOrLog(file)
+		}
+		scopes.WalkFile(v, file.File)
+		buf := new(bytes.Buffer)
+		if _, err := file.FprintPatched(buf, file.All(), v.Patches()); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != c.exp {
+			t.Errorf("Case #%d:\n%s\nExpected:\n%s\nGot:\n%s", i, c.body, c.exp, buf.String())
+		}
+	}
+}
+
+func TestOrLogKeyword(t *testing.T) {
+	defer func(keyword string) { OrLogKeyword = keyword }(OrLogKeyword)
+	OrLogKeyword = "logerr"
+	file := parsePatchable("package main\nfunc main() {\n\tx := logerr(f())\n\ty := orlog(f())\n}", t)
+	v := NewOrLog(file)
+	scopes.WalkFile(v, file.File)
+	buf := new(bytes.Buffer)
+	if _, err := file.FprintPatched(buf, file.All(), v.Patches()); err != nil {
+		t.Fatal(err)
+	}
+	exp := "package main; import gosloppylog \"log\"\nfunc main() {\n" +
+		"\tx , logassignerr_0 := (f()); if logassignerr_0 != nil { gosloppylog.Println(\"a.go:3:\", logassignerr_0) };\n" +
+		"\ty := orlog(f())\n}"
+	if buf.String() != exp {
+		t.Errorf("Expected:\n%s\nGot:\n%s", exp, buf.String())
+	}
+}
+
+func TestShortErrorMissingImports(t *testing.T) {
+	defer func(missing func(*patch.PatchableFile) []string) { patch.MissingImports = missing }(patch.MissingImports)
+	patch.MissingImports = MissingImports
+	// os is not imported yet, but auto import will import it
+	file := parsePatchable("package main\nfunc main() {\n\torlog(os.Remove(\"a\"))\n}", t)
+	v := NewOrLog(file)
+	scopes.WalkFile(v, file.File)
+	buf := new(bytes.Buffer)
+	if _, err := file.FprintPatched(buf, file.All(), v.Patches()); err != nil {
+		t.Fatal(err)
+	}
+	exp := "package main; import gosloppylog \"log\"\nfunc main() {\n" +
+		"\tif logerr_0 := (os.Remove(\"a\")); logerr_0 != nil { gosloppylog.Println(\"a.go:3:\", logerr_0) }\n}"
+	if len(v.Errors()) > 0 || buf.String() != exp {
+		t.Errorf("Expected:\n%s\nGot:\n%s\n%v", exp, buf.String(), v.Errors())
+	}
+}
+
+func TestShortErrorErrors(t *testing.T) {
+	file := parsePatchable("package main\nfunc main() {\n\tx := must(f(), g())\n\t{\n\t\tmust()\n\t}\n}", t)
+	v := NewShortError(file)
+	scopes.WalkFile(v, file.File)
+	buf := new(bytes.Buffer)
+	if err := v.Errors().FprintErrors(buf, file.Fset); err != nil {
+		t.Fatal(err)
+	}
+	exp := "a.go:3:7: 'must' builtin must be called with exactly one argument\n" +
+		"a.go:5:3: 'must' builtin must be called with exactly one argument\n"
+	if buf.String() != exp {
+		t.Errorf("Expected:\n%s\nGot:\n%s", exp, buf.String())
+	}
+}
+
+var ShortErrorCases = []struct {
+	orlog bool
+	body  string
+	exp   string
+}{
+	{
+		false,
+		"package main\nfunc main() {\n\tx := must(f())\n}",
+		"package main\nfunc main() {\n\tx , assignerr_0 := (f()); if assignerr_0 != nil { panic(\"a.go:3: \" + assignerr_0.Error()) };\n}",
+	},
+	{
+		true,
+		"package main\nfunc main() {\n\tx := orlog(f())\n}",
+		"package main; import gosloppylog \"log\"\nfunc main() {\n\tx , logassignerr_0 := (f()); if logassignerr_0 != nil { gosloppylog.Println(\"a.go:3:\", logassignerr_0) };\n}",
+	},
+	{
+		true,
+		"package main\nfunc main() {\n\tvar x int\n\tx = orlog(f())\n}",
+		"package main; import gosloppylog \"log\"\nfunc main() {\n\tvar x int\n\tlogassgn0_0, logassgnErr_1:=f(); if logassgnErr_1 != nil { gosloppylog.Println(\"a.go:4:\", logassgnErr_1) };x = logassgn0_0\n}",
+	},
+	{
+		true,
+		"package main\nfunc main() {\n\tprintln(orlog(f()))\n}",
+		"package main; import gosloppylog \"log\"\nfunc main() {\n\tvar logtmp_0, logerr_1 = f(); if logerr_1 != nil {gosloppylog.Println(\"a.go:3:\", logerr_1)};println(logtmp_0)\n}",
+	},
+	{
+		true,
+		"package main\nvar a = orlog(f())\nvar b = orlog(f())",
+		"package main; import gosloppylog \"log\"\nvar a, logtlderr_a_0 = f()\nvar b, logtlderr_a_1 = f();func init() {" +
+			"if logtlderr_a_0 != nil { gosloppylog.Println(\"a.go:2:\", logtlderr_a_0) };" +
+			"if logtlderr_a_1 != nil { gosloppylog.Println(\"a.go:3:\", logtlderr_a_1) };}",
+	},
+	{
+		false,
+		"package main\nfunc f() error\nfunc main() {\n\tmust(f())\n}",
+		"package main\nfunc f() error\nfunc main() {\n\tif err_0 := (f()); err_0 != nil { panic(\"a.go:4: \" + err_0.Error()) }\n}",
+	},
+	{
+		false,
+		"package main\ntype T int\nfunc (T) f() (int, string, error)\nfunc main() {\n\tvar t T\n\tmust(t.f())\n\ta, b := must(t.f())\n}",
+		"package main\ntype T int\nfunc (T) f() (int, string, error)\nfunc main() {\n\tvar t T\n" +
+			"\tif _, _, err_0 := (t.f()); err_0 != nil { panic(\"a.go:6: \" + err_0.Error()) }\n" +
+			"\ta, b , assignerr_1 := (t.f()); if assignerr_1 != nil { panic(\"a.go:7: \" + assignerr_1.Error()) };\n}",
+	},
+	{
+		false,
+		"package main\nfunc f() (int, string, error)\nfunc g(int, string)\nfunc main() {\n\tg(must(f()))\n}",
+		"package main\nfunc f() (int, string, error)\nfunc g(int, string)\nfunc main() {\n" +
+			"\tvar tmp_0, tmp_1, err_2 = f(); if err_2 != nil {panic(\"a.go:5: \" + err_2.Error())};g(tmp_0, tmp_1)\n}",
+	},
+	{
+		false,
+		"package main\nfunc f() (int, int)\nfunc main() {\n\ta := must(f())\n\tmust(g())\n}",
+		"package main\nfunc f() (int, int)\nfunc main() {\n\ta := must(f())\n\tmust(g())\n}",
+	},
+	{
+		false,
+		"package main\nvar a = must(f())",
+		"package main\nvar a, tlderr_a_0 = f();func init() {if tlderr_a_0 != nil { panic(\"a.go:2: \" + tlderr_a_0.Error()) };}",
+	},
+}
@@ -0,0 +1,159 @@
+package visitors
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"github.com/elazarl/gosloppy/patch"
+	"github.com/elazarl/gosloppy/scopes"
+)
+
+// TypesUnused is a scopes.Visitor that visits unused local variables and imports with the
+// given UnusedVisitor, like Unused. Unlike Unused, whether an object is used is decided by the
+// type checker, so it reports exactly the "declared and not used" and "imported and not used"
+// errors the compiler would, even when identifiers are shadowed by struct keys or selectors.
+type TypesUnused struct {
+	info *types.Info
+	// decls maps objects to the identifiers declaring them
+	decls map[*ast.Object]*ast.Ident
+	// typeSwitches maps the symbolic variable of a type switch, which has no object, to the
+	// implicit variables declared in each of its clauses
+	typeSwitches map[*ast.Ident][]types.Object
+	used         map[types.Object]bool
+	// usedDotImports holds the paths of the packages whose members were used unqualified
+	usedDotImports map[string]bool
+	// reported is needed since a variable redeclared with := is in the scope of both statements
+	reported map[*ast.Object]bool
+	Visitor  UnusedVisitor
+}
+
+// NewTypesUnused returns a scopes.Visitor that visits unused local variables and imports of
+// file, as reported by the type checker. It can replace NewUnused:
+//     scopes.WalkFile(NewTypesUnused(patchable, &PatchUnused{}), patchable.File)
+func NewTypesUnused(file *patch.PatchableFile, v UnusedVisitor) *TypesUnused {
+	u := &TypesUnused{file.TypesInfo(), make(map[*ast.Object]*ast.Ident), make(map[*ast.Ident][]types.Object),
+		make(map[types.Object]bool), make(map[string]bool), make(map[*ast.Object]bool), v}
+	// Assigning to a variable does not use it, nor does redeclaring it in a := statement.
+	assigned := make(map[*ast.Ident]bool)
+	selectors := make(map[*ast.Ident]bool)
+	ast.Inspect(file.File, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Ident:
+			if n.Obj != nil && n.Obj.Pos() == n.Pos() {
+				u.decls[n.Obj] = n
+			}
+		case *ast.AssignStmt:
+			if n.Tok == token.ASSIGN || n.Tok == token.DEFINE {
+				for _, lhs := range n.Lhs {
+					if id, ok := unparen(lhs).(*ast.Ident); ok {
+						assigned[id] = true
+					}
+				}
+			}
+		case *ast.RangeStmt:
+			if n.Tok == token.ASSIGN {
+				for _, lhs := range []ast.Expr{n.Key, n.Value} {
+					if id, ok := unparen(lhs).(*ast.Ident); ok {
+						assigned[id] = true
+					}
+				}
+			}
+		case *ast.TypeSwitchStmt:
+			if assign, ok := n.Assign.(*ast.AssignStmt); ok {
+				id := assign.Lhs[0].(*ast.Ident)
+				u.typeSwitches[id] = []types.Object{}
+				for _, clause := range n.Body.List {
+					if obj := u.info.Implicits[clause]; obj != nil {
+						u.typeSwitches[id] = append(u.typeSwitches[id], obj)
+					}
+				}
+			}
+		case *ast.SelectorExpr:
+			selectors[n.Sel] = true
+		}
+		return true
+	})
+	for id, obj := range u.info.Uses {
+		if assigned[id] {
+			continue
+		}
+		u.used[obj] = true
+		if pkg := obj.Pkg(); pkg != nil && !selectors[id] && obj.Parent() == pkg.Scope() {
+			u.usedDotImports[pkg.Path()] = true
+		}
+	}
+	return u
+}
+
+func unparen(expr ast.Expr) ast.Expr {
+	if paren, ok := expr.(*ast.ParenExpr); ok {
+		return unparen(paren.X)
+	}
+	return expr
+}
+
+func (v *TypesUnused) VisitStmt(*ast.Scope, ast.Stmt) scopes.Visitor {
+	return v
+}
+
+func (v *TypesUnused) VisitDecl(*ast.Scope, ast.Decl) scopes.Visitor {
+	return v
+}
+
+func (v *TypesUnused) VisitExpr(*ast.Scope, ast.Expr) scopes.Visitor {
+	return v
+}
+
+// unusedVar returns whether id declares a local variable the compiler would complain about
+func (v *TypesUnused) unusedVar(id *ast.Ident) bool {
+	if implicits, ok := v.typeSwitches[id]; ok {
+		for _, obj := range implicits {
+			if v.used[obj] {
+				return false
+			}
+		}
+		return true
+	}
+	obj, ok := v.info.Defs[id].(*types.Var)
+	return ok && obj.Kind() == types.LocalVar && !v.used[obj]
+}
+
+// unusedImport returns whether the compiler would complain imp is not used
+func (v *TypesUnused) unusedImport(imp *ast.ImportSpec) bool {
+	if imp.Path.Value == `"C"` || imp.Name != nil && imp.Name.Name == "_" {
+		return false
+	}
+	var obj types.Object
+	if imp.Name != nil {
+		obj = v.info.Defs[imp.Name]
+	} else {
+		obj = v.info.Implicits[imp]
+	}
+	pkgname, ok := obj.(*types.PkgName)
+	if !ok {
+		return false
+	}
+	if imp.Name != nil && imp.Name.Name == "." {
+		// we cannot know what a package we failed to import declares
+		return pkgname.Imported().Complete() && !v.usedDotImports[pkgname.Imported().Path()]
+	}
+	return !v.used[pkgname]
+}
+
+func (v *TypesUnused) ExitScope(scope *ast.Scope, node ast.Node, last bool) scopes.Visitor {
+	for _, obj := range scope.Objects {
+		if id := v.decls[obj]; id != nil && !v.reported[obj] && v.unusedVar(id) {
+			v.reported[obj] = true
+			v.Visitor.UnusedObj(obj, node)
+		}
+	}
+	if file, ok := node.(*ast.File); ok {
+		for _, imp := range file.Imports {
+			if v.unusedImport(imp) {
+				v.Visitor.UnusedImport(imp)
+			}
+		}
+	}
+	return v
+}
@@ -0,0 +1,132 @@
+package visitors
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/elazarl/gosloppy/scopes"
+)
+
+func TestTypesUnused(t *testing.T) {
+	for i, c := range TypesUnusedCases {
+		if *ncase != i && *ncase > 0 {
+			continue
+		}
+		file := parsePatchable(c.body, t)
+		unused := []string{}
+		scopes.WalkFile(NewTypesUnused(file, unusedNames(func(name string) {
+			unused = append(unused, name)
+		})), file.File)
+		sort.Strings(unused)
+		if fmt.Sprint(unused) != fmt.Sprint(c.expUnused) {
+			t.Errorf("Case #%d:\n%s\n Expected unused %v got %v", i, c.body, c.expUnused, unused)
+		}
+	}
+}
+
+var TypesUnusedCases = []struct {
+	body      string
+	expUnused []string
+}{
+	{
+		`package visitors
+		func f(a int) (r int) {
+			const c = 1
+			type T int
+			return
+		}
+		`,
+		[]string{},
+	},
+	{
+		`package visitors
+		func f() {
+			a := 1
+			a = 2
+			b := 1
+			(b) = 2
+			c := 1
+			c += 2
+		}
+		`,
+		[]string{"a", "b"},
+	},
+	{
+		`package visitors
+		func g() (int, error)
+		func f() {
+			a, err := g()
+			b, err := g()
+			println(a, b)
+		}
+		`,
+		[]string{"err"},
+	},
+	{
+		`package visitors
+		type T struct{ k int }
+		func (T) m() {}
+		func f() {
+			k := "k"
+			_ = map[string]int{k: 1}
+			m := 1
+			T{k: 1}.m()
+		}
+		`,
+		[]string{"m"},
+	},
+	{
+		`package visitors
+		func f(y interface{}) {
+			switch x := y.(type) {
+			case int:
+			}
+			switch z := y.(type) {
+			case int:
+			case string:
+				println(z)
+			}
+		}
+		`,
+		[]string{"x"},
+	},
+	{
+		`package visitors
+		func f() {
+			var a, b int
+			for a, b = range map[int]int{} {
+			}
+			var _ = func() {
+				c := 1
+			}
+		}
+		`,
+		[]string{"a", "b", "c"},
+	},
+	{
+		`package visitors
+		import "fmt"
+		import str "strings"
+		import . "bytes"
+		import _ "io"
+		import "os"
+		func f() {
+			os := 1
+			println(os)
+		}
+		`,
+		[]string{`"bytes"`, `"fmt"`, `"os"`, `"strings"`},
+	},
+	{
+		`package visitors
+		import "fmt"
+		import str "strings"
+		import . "bytes"
+		func f() {
+			fmt.Println(str.Repeat("a", 2), Compare(nil, nil))
+		}
+		`,
+		[]string{},
+	},
+}
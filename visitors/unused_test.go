@@ -1,13 +1,18 @@
 package visitors
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/elazarl/gosloppy/patch"
 	"github.com/elazarl/gosloppy/scopes"
 )
 
@@ -35,10 +40,6 @@ func (f unusedNames) UnusedImport(imp *ast.ImportSpec) {
 
 var ncase = flag.Int("case", -1, "run specific case only")
 
-func init() {
-	flag.Parse()
-}
-
 // TODO(elazar): more complex tests:
 //   1. What should happen when I `import . "foo"`, and use `var foo` from other package?
 func TestSimpleUnused(t *testing.T) {
@@ -242,3 +243,117 @@ var UnusedSimple = []struct {
 		[]string{"iface"},
 	},
 }
+
+func TestPatchUnusedWarnings(t *testing.T) {
+	file, fset := parse(`package visitors
+import "os"
+func f(a int) {
+	b := 1
+	var c = strings.TrimSpace("")
+}`, t)
+	patches := &PatchUnused{Patches: patch.Patches{}}
+	autoimport := NewAutoImporter(file)
+	scopes.WalkFile(NewMultiVisitor(NewUnused(patches), autoimport), file)
+	buf := new(bytes.Buffer)
+	if err := append(patches.Warnings, autoimport.Warnings...).Fprint(buf, fset); err != nil {
+		t.Fatal(err)
+	}
+	exp := `:2:8: "os" imported and not used (sloppified)
+:4:2: b declared and not used (sloppified)
+:5:6: c declared and not used (sloppified)
+:5:10: undefined: strings, imported "strings" (sloppified)
+`
+	if buf.String() != exp {
+		t.Errorf("Expected:\n%sGot:\n%s", exp, buf.String())
+	}
+}
+
+func TestLocalAutoImporter(t *testing.T) {
+	root, err := ioutil.TempDir("", "gosloppy.visitors.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer os.Setenv("GO111MODULE", os.Getenv("GO111MODULE"))
+	os.Setenv("GO111MODULE", "on")
+	files := map[string]string{
+		"go.mod":     "module example.com/m\n",
+		"lib/lib.go": "package lib\nfunc F() {}\n",
+		"cmd/a.go":   "package main\nimport r \"math/rand\"\nfunc a() { r.Int() }\n",
+		"cmd/b.go":   "package main\nfunc b() { r.Int(); lib.F(); rand.Int() }\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkg, err := patch.ParseFiles(filepath.Join(root, "cmd", "a.go"), filepath.Join(root, "cmd", "b.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := pkg.Files[filepath.Join(root, "cmd", "b.go")]
+	autoimport := NewLocalAutoImporter(file)
+	scopes.WalkFile(autoimport, file.File)
+	buf := new(bytes.Buffer)
+	if _, err := file.FprintPatched(buf, file.All(), autoimport.Patches); err != nil {
+		t.Fatal(err)
+	}
+	// r is imported as in a.go, and rand is ambiguous between math/rand and crypto/rand
+	exp := "package main; import r \"math/rand\"; import \"example.com/m/lib\"\nfunc b() { r.Int(); lib.F(); rand.Int() }"
+	if buf.String() != exp {
+		t.Errorf("Expected:\n%q\nGot:\n%q", exp, buf.String())
+	}
+}
+
+func TestAutoImporterSelectors(t *testing.T) {
+	file, fset := parse(`package visitors
+func f() {
+	rand.Intn(1)
+	_ = template.HTML("")
+	var w tabwriter.Writer
+	rand.Intn(rand.Int())
+	bufio.Scanner{}
+	_ = sha256.New
+	_ = scanner.Scanner{}
+}
+func g() { var sha256 int; sha256.Sum256(nil) }`, t)
+	autoimport := NewAutoImporter(file)
+	scopes.WalkFile(autoimport, file)
+	buf := new(bytes.Buffer)
+	if err := autoimport.Warnings.Fprint(buf, fset); err != nil {
+		t.Fatal(err)
+	}
+	if err := autoimport.Ambiguous.FprintErrors(buf, fset); err != nil {
+		t.Fatal(err)
+	}
+	// both go/scanner and text/scanner have a Scanner, the local sha256 is not a package
+	exp := `:3:2: undefined: rand, imported "math/rand" (sloppified)
+:4:6: undefined: template, imported "html/template" (sloppified)
+:5:8: undefined: tabwriter, imported "text/tabwriter" (sloppified)
+:7:2: undefined: bufio, imported "bufio" (sloppified)
+:8:6: undefined: sha256, imported "crypto/sha256" (sloppified)
+:9:6: undefined: scanner, could be any of "go/scanner", "text/scanner"
+`
+	if buf.String() != exp {
+		t.Errorf("Expected:\n%sGot:\n%s", exp, buf.String())
+	}
+}
+
+func TestAutoImporterInPlace(t *testing.T) {
+	file := parsePatchable("package visitors\n\nimport (\n\t\"os\"\n)\n\nfunc f() { os.Exit(strings.Count(fmt.Sprint(1), \"\")) }", t)
+	autoimport := NewLocalAutoImporter(file)
+	autoimport.InPlace = true
+	scopes.WalkFile(autoimport, file.File)
+	buf := new(bytes.Buffer)
+	if _, err := file.FprintPatched(buf, file.All(), autoimport.Patches); err != nil {
+		t.Fatal(err)
+	}
+	exp := "package visitors\n\nimport (\n\t\"fmt\"\n\t\"os\"\n\t\"strings\"\n)\n\nfunc f() { os.Exit(strings.Count(fmt.Sprint(1), \"\")) }"
+	if buf.String() != exp {
+		t.Errorf("Expected:\n%s\nGot:\n%s", exp, buf.String())
+	}
+}
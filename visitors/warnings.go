@@ -0,0 +1,46 @@
+package visitors
+
+import (
+	"fmt"
+	"go/token"
+	"io"
+	"sort"
+)
+
+// Warning is a compile error gosloppy patched away, e.g. an unused variable
+type Warning struct {
+	Pos token.Pos
+	Msg string
+}
+
+// Warnings is a list of warnings in a single file
+type Warnings []Warning
+
+func (ws Warnings) Len() int           { return len(ws) }
+func (ws Warnings) Less(i, j int) bool { return ws[i].Pos < ws[j].Pos }
+func (ws Warnings) Swap(i, j int)      { ws[i], ws[j] = ws[j], ws[i] }
+
+// Fprint writes the warnings, sorted by position, to w in the format of the go compiler
+//     a.go:4:2: i declared and not used (sloppified)
+func (ws Warnings) Fprint(w io.Writer, fset *token.FileSet) error {
+	return ws.fprint(w, fset, " (sloppified)")
+}
+
+// FprintErrors writes the warnings, sorted by position, to w as compile errors gosloppy could
+// not patch
+//     a.go:4:2: undefined: rand, could be any of "crypto/rand", "math/rand"
+func (ws Warnings) FprintErrors(w io.Writer, fset *token.FileSet) error {
+	return ws.fprint(w, fset, "")
+}
+
+func (ws Warnings) fprint(w io.Writer, fset *token.FileSet, suffix string) error {
+	sorted := append(Warnings(nil), ws...)
+	sort.Stable(sorted)
+	for _, warning := range sorted {
+		pos := fset.Position(warning.Pos)
+		if _, err := fmt.Fprintf(w, "%s:%d:%d: %s%s\n", pos.Filename, pos.Line, pos.Column, warning.Msg, suffix); err != nil {
+			return err
+		}
+	}
+	return nil
+}